@@ -0,0 +1,7 @@
+// +build sqlite
+
+package main
+
+import (
+	_ "github.com/mattes/migrate/database/sqlite"
+)
@@ -75,6 +75,21 @@ type Driver interface {
 	Drop() error
 }
 
+// VersionTracker is an optional interface for drivers that want to know
+// which migration the next call to Run belongs to, e.g. to include
+// the version in errors. Migrate calls SetCurrentVersion before each
+// call to Run.
+type VersionTracker interface {
+	SetCurrentVersion(version int)
+}
+
+// Dialecter is an optional interface for drivers that report their
+// SQL dialect, such as "sqlite3", so tools built on top of Driver can
+// generate dialect specific SQL.
+type Dialecter interface {
+	Dialect() string
+}
+
 // Open returns a new driver instance.
 func Open(url string) (Driver, error) {
 	u, err := nurl.Parse(url)
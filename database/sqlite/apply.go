@@ -0,0 +1,117 @@
+package sqlite
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/mattes/migrate/database"
+)
+
+// Migration is an up migration to apply with ApplyInOrder.
+type Migration struct {
+	Version int
+	SQL     io.Reader
+}
+
+// ApplyInOrder applies migrations the way migrate does: the version is set
+// dirty, the migration is run and the version is set clean again. Versions
+// must be ascending, otherwise nothing is applied. It stops at the first
+// migration that fails and leaves its version dirty. The database is locked
+// while migrations are applied, unless it is locked already or a stream of
+// Config.VersionDirectives runs inside of RunMany, whose transaction it
+// joins then.
+func (s *Sqlite) ApplyInOrder(migrations []Migration) (err error) {
+	defer s.handleError(&err)
+	return s.applyInOrder(migrations)
+}
+
+func (s *Sqlite) applyInOrder(migrations []Migration) (err error) {
+	for i, m := range migrations {
+		if m.SQL == nil {
+			return fmt.Errorf("migration %v has no SQL", m.Version)
+		}
+		if i > 0 && m.Version <= migrations[i-1].Version {
+			return fmt.Errorf("migration %v isn't after %v, migrations must be sorted by version", m.Version, migrations[i-1].Version)
+		}
+	}
+
+	// BEGIN EXCLUSIVE fails inside of the savepoints of RunMany
+	if !s.isLocked && s.savepoints == 0 {
+		if err := s.lock(); err != nil {
+			return err
+		}
+		defer func() {
+			if uerr := s.unlock(); err == nil {
+				err = uerr
+			}
+		}()
+	}
+
+	for _, m := range migrations {
+		if err := s.apply(m.Version, m.SQL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply applies the versions fromVersion to toVersion like ApplyInOrder,
+// getting the migration of each version from provider right before it
+// runs. Migrations which are an io.Closer are closed after running. It
+// stops at the first error: if provider fails, the version before is
+// kept, if the migration fails, its version is left dirty. The database
+// is locked while migrations are applied, unless it is locked already.
+func (s *Sqlite) Apply(fromVersion, toVersion int, provider func(v int) (io.Reader, error)) (err error) {
+	defer s.handleError(&err)
+
+	if fromVersion < 0 || fromVersion > toVersion {
+		return fmt.Errorf("invalid version range %v to %v", fromVersion, toVersion)
+	}
+	if provider == nil {
+		return fmt.Errorf("no migration provider")
+	}
+
+	if !s.isLocked {
+		if err := s.lock(); err != nil {
+			return err
+		}
+		defer func() {
+			if uerr := s.unlock(); err == nil {
+				err = uerr
+			}
+		}()
+	}
+
+	for v := fromVersion; v <= toVersion; v++ {
+		r, err := provider(v)
+		if err != nil {
+			return fmt.Errorf("migration %v: %v", v, err)
+		}
+		if r == nil {
+			return fmt.Errorf("migration %v has no SQL", v)
+		}
+		err = s.apply(v, r)
+		if c, ok := r.(io.Closer); ok {
+			c.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply sets version dirty, runs migration and sets version clean.
+func (s *Sqlite) apply(version int, migration io.Reader) error {
+	if err := s.setVersion(version, true); err != nil {
+		return err
+	}
+	s.SetCurrentVersion(version)
+	defer func() {
+		s.currentVersion = database.NilVersion
+	}()
+	if err := s.runTimed(migration); err != nil {
+		return err
+	}
+	return s.setVersion(version, false)
+}
@@ -0,0 +1,126 @@
+package sqlite
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mattes/migrate/database"
+)
+
+func TestApplyInOrder(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	err := d.ApplyInOrder([]Migration{
+		{Version: 1, SQL: strings.NewReader("CREATE TABLE foo (foo text);")},
+		{Version: 2, SQL: strings.NewReader("CREATE TABLE bar (bar text); INSERT INTO missing VALUES (1);")},
+		{Version: 3, SQL: strings.NewReader("CREATE TABLE baz (baz text);")},
+	})
+	if _, ok := err.(database.Error); !ok {
+		t.Fatalf("expected the second migration to fail, got %v", err)
+	}
+
+	version, dirty, err := d.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 || !dirty {
+		t.Fatalf("expected dirty version 2, got %v (dirty %v)", version, dirty)
+	}
+	if !tableExists(t, d, "main", "foo") {
+		t.Fatal("expected table foo to be created")
+	}
+	for _, table := range []string{"bar", "baz"} {
+		if tableExists(t, d, "main", table) {
+			t.Fatalf("expected table %v not to be created", table)
+		}
+	}
+	if d.isLocked {
+		t.Fatal("expected the database to be unlocked")
+	}
+
+	// unsorted migrations aren't applied at all
+	if err := d.SetVersion(3, false); err != nil {
+		t.Fatal(err)
+	}
+	err = d.ApplyInOrder([]Migration{
+		{Version: 5, SQL: strings.NewReader("CREATE TABLE qux (qux text);")},
+		{Version: 4, SQL: strings.NewReader("SELECT 1;")},
+	})
+	if err == nil {
+		t.Fatal("expected unsorted migrations to fail")
+	}
+	if tableExists(t, d, "main", "qux") {
+		t.Fatal("expected table qux not to be created")
+	}
+}
+
+func TestApply(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	migrations := map[int]string{
+		1: "CREATE TABLE foo (foo text);",
+		2: "CREATE TABLE bar (bar text); INSERT INTO missing VALUES (1);",
+		3: "CREATE TABLE baz (baz text);",
+	}
+	var provided []int
+	provider := func(v int) (io.Reader, error) {
+		provided = append(provided, v)
+		migration, ok := migrations[v]
+		if !ok {
+			return nil, fmt.Errorf("no migration %v", v)
+		}
+		return strings.NewReader(migration), nil
+	}
+
+	err := d.Apply(1, 3, provider)
+	if _, ok := err.(database.Error); !ok {
+		t.Fatalf("expected version 2 to fail, got %v", err)
+	}
+	if fmt.Sprint(provided) != "[1 2]" {
+		t.Fatalf("expected versions 1 and 2 to be provided, got %v", provided)
+	}
+	version, dirty, err := d.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 || !dirty {
+		t.Fatalf("expected dirty version 2, got %v (dirty %v)", version, dirty)
+	}
+	if !tableExists(t, d, "main", "foo") {
+		t.Fatal("expected table foo to be created")
+	}
+	for _, table := range []string{"bar", "baz"} {
+		if tableExists(t, d, "main", table) {
+			t.Fatalf("expected table %v not to be created", table)
+		}
+	}
+	if d.isLocked {
+		t.Fatal("expected the database to be unlocked")
+	}
+
+	// a failing provider keeps the version before
+	migrations[2] = "CREATE TABLE bar (bar text);"
+	if err := d.SetVersion(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Apply(2, 4, provider); err == nil {
+		t.Fatal("expected the missing version 4 to fail")
+	}
+	if version, dirty, err = d.Version(); err != nil || version != 3 || dirty {
+		t.Fatalf("expected clean version 3, got %v (dirty %v, %v)", version, dirty, err)
+	}
+
+	if err := d.Apply(3, 2, provider); err == nil {
+		t.Fatal("expected an invalid range to fail")
+	}
+}
@@ -0,0 +1,93 @@
+package sqlite
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mattes/migrate/database"
+)
+
+// checksum returns the hex encoded SHA-256 of a migration.
+func checksum(migration []byte) string {
+	sum := sha256.Sum256(migration)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizedChecksum returns the checksum of a migration normalized by
+// normalizeSQL, so comments and whitespace don't change it.
+func normalizedChecksum(migration []byte) string {
+	return checksum([]byte(normalizeSQL(string(migration))))
+}
+
+// ErrChecksumMismatch is returned by RunVerified if the SHA-256
+// of a migration isn't the expected one.
+type ErrChecksumMismatch struct {
+	Expected string
+	Actual   string
+}
+
+func (e ErrChecksumMismatch) Error() string {
+	return fmt.Sprintf("checksum mismatch: expected sha256 %v, got %v", e.Expected, e.Actual)
+}
+
+// RunVerified runs the migration read from r, such as a response body, if its
+// hex encoded SHA-256 is expectedSHA. The migration is read completely and
+// verified before anything is run, otherwise ErrChecksumMismatch is returned.
+func (s *Sqlite) RunVerified(r io.Reader, expectedSHA string) (err error) {
+	defer s.handleError(&err)
+
+	migr, err := s.readMigration(r)
+	if err != nil {
+		return err
+	}
+	if sum := checksum(migr); sum != strings.ToLower(expectedSHA) {
+		return ErrChecksumMismatch{Expected: expectedSHA, Actual: sum}
+	}
+	return s.run(bytes.NewReader(migr))
+}
+
+// lastChecksum returns the checksum stored with the latest version,
+// which is the checksum of the last migration run. It returns an empty
+// string if there is none.
+func (s *Sqlite) lastChecksum() (string, error) {
+	var sum sql.NullString
+	query := `SELECT checksum FROM ` + quoteIdentifier(s.config.MigrationsTable) + ` ORDER BY version DESC LIMIT 1`
+	err := s.db.QueryRow(query).Scan(&sum)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", nil
+	case err != nil:
+		return "", &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return sum.String, nil
+}
+
+// takeChecksum returns the checksum to store with the next version and
+// forgets it. nil keeps the checksum of the previous version.
+func (s *Sqlite) takeChecksum() interface{} {
+	sum := s.checksum
+	s.checksum = ""
+	if len(sum) == 0 {
+		return nil
+	}
+	return sum
+}
+
+// ensureChecksumColumn adds the checksum column to
+// migrations tables created without checksum mode.
+func (s *Sqlite) ensureChecksumColumn() error {
+	exists, err := s.columnExists(s.config.MigrationsTable, "checksum")
+	if err != nil || exists {
+		return err
+	}
+	query := `ALTER TABLE ` + quoteIdentifier(s.config.MigrationsTable) + ` ADD COLUMN checksum text`
+	if _, err := s.db.Exec(query); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package sqlite
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestChecksumSkipsIdenticalMigration(t *testing.T) {
+	for _, history := range []bool{false, true} {
+		dir, cleanup := tempDir(t)
+		log := &testLogger{}
+		d := open(t, dir, fmt.Sprintf("?x-checksum=on&x-history=%v", history))
+		d.SetLogger(log)
+
+		migration := []byte("CREATE TABLE IF NOT EXISTS foo (foo text); INSERT INTO foo VALUES ('bar');")
+		for version := 1; version <= 2; version++ {
+			if err := d.SetVersion(version, true); err != nil {
+				t.Fatal(err)
+			}
+			d.SetCurrentVersion(version)
+			if err := d.Run(bytes.NewReader(migration)); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.SetVersion(version, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var count int
+		if err := d.db.QueryRow(`SELECT COUNT(*) FROM foo`).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Errorf("history %v: expected the second migration to be skipped, got %v rows", history, count)
+		}
+		if !log.contains("migration 2 is identical") {
+			t.Errorf("history %v: expected a warning, got %q", history, log.lines)
+		}
+
+		var sum sql.NullString
+		if err := d.db.QueryRow(`SELECT checksum FROM schema_migrations WHERE version = 2`).Scan(&sum); err != nil {
+			t.Fatal(err)
+		}
+		if sum.String != normalizedChecksum(migration) {
+			t.Errorf("history %v: expected checksum %v, got %v", history, normalizedChecksum(migration), sum.String)
+		}
+
+		// different content runs
+		if err := d.Run(bytes.NewReader([]byte("INSERT INTO foo VALUES ('baz');"))); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.db.QueryRow(`SELECT COUNT(*) FROM foo`).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Errorf("history %v: expected 2 rows, got %v", history, count)
+		}
+
+		d.Close()
+		cleanup()
+	}
+}
+
+func TestNormalizedChecksum(t *testing.T) {
+	migration := []byte("CREATE TABLE foo (id int, name text DEFAULT 'a  b');\nINSERT INTO foo (id) VALUES (1);")
+	reformatted := []byte(`-- create foo
+CREATE TABLE foo (
+	id   int,
+	name text DEFAULT 'a  b' /* keep the spaces */
+);
+
+INSERT INTO foo(id) VALUES(1) ;
+`)
+	if normalizedChecksum(migration) != normalizedChecksum(reformatted) {
+		t.Errorf("expected reformatting to keep the checksum, got %q and %q", normalizeSQL(string(migration)), normalizeSQL(string(reformatted)))
+	}
+
+	for _, changed := range []string{
+		"CREATE TABLE foo (id int, name text DEFAULT 'a b');\nINSERT INTO foo (id) VALUES (1);",
+		"CREATE TABLE foo (id int, name text DEFAULT 'a  b');\nINSERT INTO foo (id) VALUES (12);",
+		"CREATE TABLE foo (id int, name text DEFAULT 'a  b');\nINSERT INTO foo (id) VALUES (1, 2);",
+	} {
+		if normalizedChecksum(migration) == normalizedChecksum([]byte(changed)) {
+			t.Errorf("expected %q to change the checksum", changed)
+		}
+	}
+}
+
+func TestChecksumColumnAdded(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	if err := d.SetVersion(1, false); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	d = open(t, dir, "?x-checksum=on")
+	defer d.Close()
+	exists, err := d.ColumnExists("schema_migrations", "checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("expected checksum column to be added")
+	}
+	if version, _, err := d.Version(); err != nil || version != 1 {
+		t.Fatalf("expected version 1, got %v (%v)", version, err)
+	}
+}
+
+func TestRunVerified(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	migration := []byte("CREATE TABLE foo (foo text);")
+	sum := sha256.Sum256(migration)
+	expected := hex.EncodeToString(sum[:])
+
+	err := d.RunVerified(bytes.NewReader([]byte("CREATE TABLE bar (bar text);")), expected)
+	if e, ok := err.(ErrChecksumMismatch); !ok || e.Expected != expected {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+	if tableExists(t, d, "main", "bar") {
+		t.Fatal("expected table bar not to be created")
+	}
+
+	if err := d.RunVerified(bytes.NewReader(migration), strings.ToUpper(expected)); err != nil {
+		t.Fatal(err)
+	}
+	if !tableExists(t, d, "main", "foo") {
+		t.Fatal("expected table foo to be created")
+	}
+}
@@ -0,0 +1,32 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql/driver"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// hookConnector opens connections with go-sqlite3, running hook on each
+// new connection before it is used. database/sql may open connections at
+// any time, to replace broken ones for one, so anything a connection
+// needs, like the seeded random() of RandomSeed, must be set up there.
+type hookConnector struct {
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+}
+
+func newHookConnector(dsn string, hook func(*sqlite3.SQLiteConn) error) *hookConnector {
+	return &hookConnector{
+		dsn:    dsn,
+		driver: &sqlite3.SQLiteDriver{ConnectHook: hook},
+	}
+}
+
+func (c *hookConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *hookConnector) Driver() driver.Driver {
+	return c.driver
+}
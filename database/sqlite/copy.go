@@ -0,0 +1,72 @@
+package sqlite
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattes/migrate/database"
+)
+
+// CopyTo creates the tables, indexes, views and triggers of the database
+// in dst, for building test fixtures, and copies the rows of the tables
+// too if includeData is set. Rows are copied before indexes and triggers
+// are created. The migrations tables are left out, dst keeps its own.
+// Everything is copied in a single transaction of dst, which is rolled
+// back if anything fails. Unlike Restore, dst doesn't have to be empty.
+func (s *Sqlite) CopyTo(dst *Sqlite, includeData bool) (err error) {
+	defer s.handleError(&err)
+
+	if dst == nil || dst == s {
+		return fmt.Errorf("copy: invalid destination")
+	}
+	if dst.config.ReadOnly {
+		return ErrReadOnly
+	}
+
+	objects, err := s.schemaObjects()
+	if err != nil {
+		return err
+	}
+	skip := func(obj schemaObject) bool {
+		return obj.typ == "table" && (strings.EqualFold(obj.name, s.config.MigrationsTable) || strings.EqualFold(obj.name, dst.config.MigrationsTable))
+	}
+
+	return dst.transactionally(func() error {
+		// sqlite_sequence is created along with the first AUTOINCREMENT
+		// table, the internal tables are SQLite's business
+		tables := make(map[string]bool)
+		for _, obj := range objects {
+			if obj.typ != "table" || skip(obj) || isInternalTable(obj.name) {
+				continue
+			}
+			if _, err := dst.db.Exec(obj.sql); err != nil {
+				return &database.Error{OrigErr: err, Query: []byte(obj.sql)}
+			}
+			tables[strings.ToLower(obj.name)] = true
+			if !includeData {
+				continue
+			}
+			n, err := s.insertRows(dst.db.Prepare, obj.name)
+			if err != nil {
+				return fmt.Errorf("copy rows of table %v: %v", obj.name, err)
+			}
+			s.logVerbosePrintf("copy: copied %v rows of table %v\n", n, obj.name)
+		}
+
+		if includeData && hasTable(objects, "sqlite_sequence") {
+			if _, err := s.copySequences(dst.db, tables); err != nil {
+				return fmt.Errorf("copy sqlite_sequence: %v", err)
+			}
+		}
+
+		for _, obj := range objects {
+			if obj.typ == "table" {
+				continue
+			}
+			if _, err := dst.db.Exec(obj.sql); err != nil {
+				return &database.Error{OrigErr: err, Query: []byte(obj.sql)}
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,130 @@
+package sqlite
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyTo(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	src := open(t, dir, "")
+	defer src.Close()
+	migration := `CREATE TABLE authors (id integer primary key autoincrement, name text not null);
+CREATE TABLE books (id int, author_id int REFERENCES authors (id), title text);
+CREATE INDEX books_title ON books (title);
+CREATE VIEW titles AS SELECT title FROM books;
+CREATE TRIGGER books_insert AFTER INSERT ON books BEGIN UPDATE authors SET name = upper(name) WHERE id = new.author_id; END;
+INSERT INTO authors (name) VALUES ('ann'), ('bob'), ('cid');
+DELETE FROM authors WHERE name = 'cid';
+INSERT INTO books VALUES (1, 1, 'one'), (2, 2, 'two'), (3, 2, 'three');`
+	if err := src.Run(bytes.NewReader([]byte(migration))); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.SetVersion(7, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, includeData := range []bool{false, true} {
+		dstDir := filepath.Join(dir, "dst")
+		os.RemoveAll(dstDir)
+		if err := os.MkdirAll(dstDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		dst := open(t, dstDir, "")
+
+		if err := src.CopyTo(dst, includeData); err != nil {
+			t.Fatalf("includeData %v: %v", includeData, err)
+		}
+
+		for _, table := range []string{"authors", "books"} {
+			if !tableExists(t, dst, "main", table) {
+				t.Fatalf("includeData %v: expected table %v to be copied", includeData, table)
+			}
+		}
+		var objects int
+		if err := dst.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name IN ('books_title', 'titles', 'books_insert')`).Scan(&objects); err != nil {
+			t.Fatal(err)
+		}
+		if objects != 3 {
+			t.Fatalf("includeData %v: expected the index, view and trigger to be copied, got %v", includeData, objects)
+		}
+
+		expected := 0
+		if includeData {
+			expected = 3
+		}
+		var books int
+		if err := dst.db.QueryRow(`SELECT COUNT(*) FROM titles`).Scan(&books); err != nil {
+			t.Fatal(err)
+		}
+		if books != expected {
+			t.Fatalf("includeData %v: expected %v books, got %v", includeData, expected, books)
+		}
+		if includeData {
+			// rows are copied as is, before the trigger exists
+			var name string
+			if err := dst.db.QueryRow(`SELECT name FROM authors WHERE id = 1`).Scan(&name); err != nil {
+				t.Fatal(err)
+			}
+			if name != "ANN" {
+				t.Fatalf("expected the author's name as in the source, got %q", name)
+			}
+			var id int
+			if err := dst.db.QueryRow(`INSERT INTO authors (name) VALUES ('cat') RETURNING id`).Scan(&id); err != nil {
+				t.Fatal(err)
+			}
+			if id != 4 {
+				t.Fatalf("expected the AUTOINCREMENT sequence to be copied, got id %v", id)
+			}
+			rows, err := dst.db.Query(`SELECT name, COUNT(*) FROM sqlite_sequence GROUP BY name`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for rows.Next() {
+				var table string
+				var n int
+				if err := rows.Scan(&table, &n); err != nil {
+					t.Fatal(err)
+				}
+				if n != 1 {
+					t.Fatalf("expected one sqlite_sequence row of table %v, got %v", table, n)
+				}
+			}
+			if err := rows.Err(); err != nil {
+				t.Fatal(err)
+			}
+			rows.Close()
+		}
+
+		// dst keeps its own version
+		if version, _, err := dst.Version(); err != nil || version != -1 {
+			t.Fatalf("includeData %v: expected no version, got %v (%v)", includeData, version, err)
+		}
+
+		dst.Close()
+	}
+
+	// a failing copy is rolled back
+	failingDir := filepath.Join(dir, "failing")
+	if err := os.MkdirAll(failingDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	dst := open(t, failingDir, "")
+	defer dst.Close()
+	if err := dst.Run(bytes.NewReader([]byte("CREATE TABLE books (id int);"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.CopyTo(dst, true); err == nil {
+		t.Fatal("expected copying onto an existing table to fail")
+	}
+	if tableExists(t, dst, "main", "authors") {
+		t.Fatal("expected table authors to be rolled back")
+	}
+	if src.CopyTo(src, false) == nil {
+		t.Fatal("expected copying onto itself to fail")
+	}
+}
@@ -0,0 +1,96 @@
+package sqlite
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrUndefinedVariable is returned by Run with Config.ExpandEnv if a
+// migration references an environment variable that isn't set.
+type ErrUndefinedVariable struct {
+	Name string
+}
+
+func (e ErrUndefinedVariable) Error() string {
+	return fmt.Sprintf("environment variable %v is not set", e.Name)
+}
+
+// expandEnv replaces $NAME and ${NAME} in a migration with the value
+// lookup returns for NAME. String literals, quoted identifiers and
+// comments are left alone, so values can't be injected into them.
+// Undefined variables fail with ErrUndefinedVariable, unless relaxed
+// is set, which replaces them with the empty string. A $ which isn't
+// followed by a name is kept, as is one inside of an identifier like
+// price$usd, which SQLite allows.
+func expandEnv(migration string, lookup func(string) (string, bool), relaxed bool) (string, error) {
+	var out strings.Builder
+	s := &scanner{src: migration}
+
+	start := 0
+	for !s.done() {
+		switch c := s.peek(); {
+		case c == '\'' || c == '"' || c == '`':
+			s.skipQuoted(c)
+
+		case c == '[':
+			s.skipQuoted(']')
+
+		case c == '-' && s.peekAt(1) == '-':
+			s.skipLineComment()
+
+		case c == '/' && s.peekAt(1) == '*':
+			s.skipBlockComment()
+
+		case isIdentStart(c):
+			for !s.done() && isIdentChar(s.peek()) {
+				s.pos++
+			}
+
+		case c == '$':
+			ref := s.pos
+			s.pos++
+			braces := s.peekAt(0) == '{'
+			if braces {
+				s.pos++
+			}
+			name := s.readEnvName()
+			if len(name) == 0 || (braces && s.peekAt(0) != '}') {
+				// not a reference, a lone $
+				s.pos = ref + 1
+				continue
+			}
+			if braces {
+				s.pos++
+			}
+
+			value, ok := lookup(name)
+			if !ok && !relaxed {
+				return "", ErrUndefinedVariable{Name: name}
+			}
+			out.WriteString(migration[start:ref])
+			out.WriteString(value)
+			start = s.pos
+
+		default:
+			s.pos++
+		}
+	}
+
+	out.WriteString(migration[start:])
+	return out.String(), nil
+}
+
+// readEnvName reads an environment variable name, ASCII letters,
+// digits and underscores not starting with a digit.
+func (s *scanner) readEnvName() string {
+	start := s.pos
+	for !s.done() {
+		c := s.peek()
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (s.pos > start && c >= '0' && c <= '9') {
+			s.pos++
+			continue
+		}
+		break
+	}
+	return s.src[start:s.pos]
+}
@@ -0,0 +1,82 @@
+package sqlite
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	env := map[string]string{"TABLE": "users", "SUFFIX": "_v2", "N1": "1"}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	tt := []struct {
+		migration string
+		relaxed   bool
+		expected  string
+		undefined string
+	}{
+		{"SELECT * FROM $TABLE", false, "SELECT * FROM users", ""},
+		{"CREATE TABLE ${TABLE}${SUFFIX} (id int);", false, "CREATE TABLE users_v2 (id int);", ""},
+		{"SELECT $N1, $1, $, ${, ${TABLE", false, "SELECT 1, $1, $, ${, ${TABLE", ""},
+		{"INSERT INTO $TABLE VALUES ('$TABLE', \"${TABLE}\", [$TABLE]) -- $TABLE\n/* $TABLE */", false,
+			"INSERT INTO users VALUES ('$TABLE', \"${TABLE}\", [$TABLE]) -- $TABLE\n/* $TABLE */", ""},
+		{"SELECT '$TABLE'", false, "SELECT '$TABLE'", ""},
+		{"SELECT $MISSING FROM $TABLE", false, "", "MISSING"},
+		{"SELECT 1 ${MISSING}FROM $TABLE", true, "SELECT 1 FROM users", ""},
+		{"SELECT price$usd, price$TABLE FROM $TABLE", false, "SELECT price$usd, price$TABLE FROM users", ""},
+		{"SELECT price$usd FROM $TABLE", true, "SELECT price$usd FROM users", ""},
+	}
+
+	for i, v := range tt {
+		expanded, err := expandEnv(v.migration, lookup, v.relaxed)
+		if len(v.undefined) > 0 {
+			if e, ok := err.(ErrUndefinedVariable); !ok || e.Name != v.undefined {
+				t.Errorf("%v: expected ErrUndefinedVariable for %v, got %v", i, v.undefined, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", i, err)
+			continue
+		}
+		if expanded != v.expected {
+			t.Errorf("%v: expected %q, got %q", i, v.expected, expanded)
+		}
+	}
+}
+
+func TestRunExpandEnv(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	os.Setenv("SQLITE_DRIVER_TEST_TABLE", "expanded")
+	defer os.Unsetenv("SQLITE_DRIVER_TEST_TABLE")
+
+	d := open(t, dir, "?x-expand-env=on")
+	defer d.Close()
+
+	migration := "CREATE TABLE $SQLITE_DRIVER_TEST_TABLE (name text); INSERT INTO ${SQLITE_DRIVER_TEST_TABLE} VALUES ('$SQLITE_DRIVER_TEST_TABLE');"
+	if err := d.Run(bytes.NewReader([]byte(migration))); err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	if err := d.db.QueryRow(`SELECT name FROM expanded`).Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "$SQLITE_DRIVER_TEST_TABLE" {
+		t.Fatalf("expected the string literal to be kept, got %q", name)
+	}
+
+	err := d.Run(bytes.NewReader([]byte("CREATE TABLE $SQLITE_DRIVER_TEST_MISSING (name text);")))
+	if _, ok := err.(ErrUndefinedVariable); !ok {
+		t.Fatalf("expected ErrUndefinedVariable, got %v", err)
+	}
+
+	if _, err := (&Sqlite{}).Open("sqlite3://" + dir + "/sqlite.db?x-expand-env=sometimes"); err == nil {
+		t.Fatal("expected an invalid x-expand-env to fail")
+	}
+}
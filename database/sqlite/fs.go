@@ -0,0 +1,17 @@
+package sqlite
+
+import (
+	"io/fs"
+)
+
+// RunFS runs the migration name of fsys, e.g. an embed.FS.
+func (s *Sqlite) RunFS(fsys fs.FS, name string) (err error) {
+	defer s.handleError(&err)
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.run(f)
+}
@@ -0,0 +1,28 @@
+package sqlite
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestRunFS(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	fsys := fstest.MapFS{
+		"migrations/1_foo.up.sql": &fstest.MapFile{Data: []byte("CREATE TABLE foo (foo text);")},
+	}
+	if err := d.RunFS(fsys, "migrations/1_foo.up.sql"); err != nil {
+		t.Fatal(err)
+	}
+	if !tableExists(t, d, "main", "foo") {
+		t.Fatal("expected table foo to exist")
+	}
+
+	if err := d.RunFS(fsys, "migrations/2_missing.up.sql"); err == nil {
+		t.Fatal("expected err not to be nil")
+	}
+}
@@ -0,0 +1,61 @@
+package sqlite
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattes/migrate/database"
+)
+
+// integrityChecks maps the modes of Config.IntegrityCheck to their pragma.
+var integrityChecks = map[string]string{
+	"quick": "quick_check",
+	"full":  "integrity_check",
+}
+
+// ErrIntegrityCheck is returned by Unlock if the integrity check set by
+// Config.IntegrityCheck didn't return ok. The migrations are committed
+// already, Result lists the problems found.
+type ErrIntegrityCheck struct {
+	Pragma string
+	Result []string
+}
+
+func (e ErrIntegrityCheck) Error() string {
+	return fmt.Sprintf("%v failed: %v", e.Pragma, strings.Join(e.Result, "; "))
+}
+
+// checkIntegrity runs the integrity check set by Config.IntegrityCheck, if any.
+// https://www.sqlite.org/pragma.html#pragma_integrity_check
+func (s *Sqlite) checkIntegrity() error {
+	if len(s.config.IntegrityCheck) == 0 {
+		return nil
+	}
+	// the mode is validated by WithInstance
+	pragma := integrityChecks[strings.ToLower(s.config.IntegrityCheck)]
+
+	query := `PRAGMA ` + pragma
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return &database.Error{OrigErr: err, Query: []byte(query)}
+		}
+		result = append(result, line)
+	}
+	if err := rows.Err(); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+
+	if len(result) != 1 || result[0] != "ok" {
+		return ErrIntegrityCheck{Pragma: pragma, Result: result}
+	}
+	s.logVerbosePrintf("%v ok\n", pragma)
+	return nil
+}
@@ -0,0 +1,95 @@
+package sqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestIntegrityCheck(t *testing.T) {
+	for _, mode := range []string{"quick", "full"} {
+		dir, cleanup := tempDir(t)
+		defer cleanup()
+
+		d := open(t, dir, "?x-ignore-check-constraints=on&x-integrity-check="+mode)
+		defer d.Close()
+
+		if err := d.Lock(); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Run(bytes.NewReader([]byte("CREATE TABLE foo (foo int CHECK (foo > 0)); INSERT INTO foo VALUES (1);"))); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Unlock(); err != nil {
+			t.Fatalf("%v: %v", mode, err)
+		}
+
+		if err := d.Lock(); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Run(bytes.NewReader([]byte("INSERT INTO foo VALUES (-1);"))); err != nil {
+			t.Fatal(err)
+		}
+		err := d.Unlock()
+		if e, ok := err.(ErrIntegrityCheck); !ok || e.Pragma != integrityChecks[mode] {
+			t.Fatalf("%v: expected ErrIntegrityCheck, got %v", mode, err)
+		}
+		if d.Locked() {
+			t.Fatalf("%v: expected the database to be unlocked", mode)
+		}
+	}
+}
+
+func TestIntegrityCheckIndex(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	// make index foo_bar index the wrong column
+	path := filepath.Join(dir, "sqlite.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	for _, query := range []string{
+		`CREATE TABLE foo (foo int, bar int)`,
+		`CREATE INDEX foo_bar ON foo (bar)`,
+		`INSERT INTO foo VALUES (1, 2)`,
+		`PRAGMA writable_schema = ON`,
+		`UPDATE sqlite_master SET sql = 'CREATE INDEX foo_bar ON foo (foo)' WHERE name = 'foo_bar'`,
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	// quick_check doesn't look at index content
+	quick := open(t, dir, "?x-integrity-check=quick")
+	if err := quick.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := quick.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	quick.Close()
+
+	full := open(t, dir, "?x-integrity-check=full")
+	defer full.Close()
+	if err := full.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := full.Unlock().(ErrIntegrityCheck); !ok {
+		t.Fatal("expected ErrIntegrityCheck")
+	}
+}
+
+func TestIntegrityCheckInvalid(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	if _, err := (&Sqlite{}).Open("sqlite3://" + filepath.Join(dir, "sqlite.db") + "?x-integrity-check=thorough"); err == nil {
+		t.Fatal("expected err not to be nil")
+	}
+}
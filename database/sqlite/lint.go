@@ -0,0 +1,141 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// ErrForeignSQL is returned by Run in lint mode if a statement uses
+// SQL of another database, which SQLite rejects or treats differently.
+type ErrForeignSQL struct {
+	// Token is the offending token
+	Token string
+
+	// Hint suggests what to use instead
+	Hint string
+
+	Statement string
+}
+
+func (e ErrForeignSQL) Error() string {
+	return fmt.Sprintf("%v is not SQLite SQL, %v: %v", e.Token, e.Hint, e.Statement)
+}
+
+// foreignTypes are column types of other databases, followed by what
+// to use in SQLite instead. SQLite accepts any type name, but SERIAL
+// columns don't auto increment.
+var foreignTypes = map[string]string{
+	"SERIAL":      "use INTEGER PRIMARY KEY",
+	"BIGSERIAL":   "use INTEGER PRIMARY KEY",
+	"SMALLSERIAL": "use INTEGER PRIMARY KEY",
+}
+
+// foreignFuncs are functions of other databases.
+var foreignFuncs = map[string]string{
+	"NOW":     "use CURRENT_TIMESTAMP",
+	"GETDATE": "use CURRENT_TIMESTAMP",
+}
+
+func isWord(token string) bool {
+	return len(token) > 0 && isIdentStart(token[0])
+}
+
+// lintStatement checks stmt for common constructs of PostgreSQL and MySQL.
+// String literals, quoted identifiers and comments are skipped.
+func lintStatement(stmt string) error {
+	tokens := tokenize(stmt)
+	at := func(i int) string {
+		if i < 0 || i >= len(tokens) {
+			return ""
+		}
+		return tokens[i]
+	}
+
+	for i, token := range tokens {
+		switch {
+		case token == "`":
+			return ErrForeignSQL{Token: token, Hint: `quote identifiers with "double quotes"`, Statement: stmt}
+
+		case token == "::":
+			return ErrForeignSQL{Token: token, Hint: "use CAST(expr AS type)", Statement: stmt}
+
+		case token == "AUTO_INCREMENT":
+			return ErrForeignSQL{Token: token, Hint: "use INTEGER PRIMARY KEY AUTOINCREMENT", Statement: stmt}
+
+		case foreignTypes[token] != "" && isWord(at(i-1)) && (at(i-2) == "(" || at(i-2) == ","):
+			// column definition, as in "(id SERIAL"
+			return ErrForeignSQL{Token: token, Hint: foreignTypes[token], Statement: stmt}
+
+		case foreignFuncs[token] != "" && at(i+1) == "(":
+			return ErrForeignSQL{Token: token + "()", Hint: foreignFuncs[token], Statement: stmt}
+
+		case (token == "ENGINE" || token == "CHARSET") && at(i+1) == "=" && (at(i-1) == ")" || at(i-1) == "DEFAULT"):
+			// table options follow the column definitions
+			return ErrForeignSQL{Token: token + "=", Hint: "SQLite has no table options", Statement: stmt}
+		}
+	}
+	return nil
+}
+
+// ErrInvalidSQL is returned by ValidateSQL for a statement SQLite
+// can't compile.
+type ErrInvalidSQL struct {
+	Statement string
+
+	// Line and Column are where the statement starts in
+	// the migration, counting from 1
+	Line   int
+	Column int
+
+	OrigErr error
+}
+
+func (e ErrInvalidSQL) Error() string {
+	return fmt.Sprintf("invalid statement at line %v, column %v: %v: %v", e.Line, e.Column, e.OrigErr, e.Statement)
+}
+
+// ValidateSQL compiles every statement of migration without executing it,
+// so syntax errors can be caught in a pre-commit hook. It needs no database,
+// the statements are prepared against an empty in-memory database, so
+// errors about unknown tables, columns, functions and the like are ignored.
+// It returns ErrInvalidSQL for the first statement that doesn't compile.
+func ValidateSQL(migration string) error {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	offset := 0
+	for _, stmt := range splitStatements(migration) {
+		start := offset + strings.Index(migration[offset:], stmt)
+		offset = start + len(stmt)
+		if isEmptyStatement(stmt) {
+			continue
+		}
+
+		prepared, err := db.Prepare(stmt)
+		if err == nil {
+			prepared.Close()
+			continue
+		}
+		if e, ok := sqliteError(err); ok && strings.HasPrefix(e.Error(), "no such ") {
+			continue
+		}
+		line := strings.Count(migration[:start], "\n") + 1
+		column := start - strings.LastIndex(migration[:start], "\n")
+		return ErrInvalidSQL{Statement: stmt, Line: line, Column: column, OrigErr: err}
+	}
+	return nil
+}
+
+// isEmptyStatement reports if stmt is nothing but semicolons and comments.
+func isEmptyStatement(stmt string) bool {
+	for _, token := range tokenize(stmt) {
+		if token != ";" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package sqlite
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLintStatement(t *testing.T) {
+	tt := []struct {
+		stmt  string
+		token string
+	}{
+		{"CREATE TABLE foo (id SERIAL PRIMARY KEY)", "SERIAL"},
+		{"CREATE TABLE foo (id bigserial)", "BIGSERIAL"},
+		{"CREATE TABLE foo (id int AUTO_INCREMENT)", "AUTO_INCREMENT"},
+		{"INSERT INTO foo (created) VALUES (now ())", "NOW()"},
+		{"CREATE TABLE `foo` (id int)", "`"},
+		{"CREATE TABLE foo (id int) ENGINE=InnoDB", "ENGINE="},
+		{"CREATE TABLE foo (id int) DEFAULT CHARSET = utf8", "CHARSET="},
+		{"SELECT '1'::int", "::"},
+		{"SERIAL", ""},
+		{"SELECT serial, now FROM foo", ""},
+
+		// no false positives
+		{"CREATE TABLE foo (id INTEGER PRIMARY KEY AUTOINCREMENT, serial text, engine text)", ""},
+		{"INSERT INTO foo (serial, engine) VALUES ('SERIAL', 'ENGINE=InnoDB')", ""},
+		{"SELECT 'now()', \"now\", [x::y]", ""},
+		{"SELECT 1 -- ENGINE=InnoDB `foo`\n/* SERIAL now() */", ""},
+		{"UPDATE foo SET engine = 'x'", ""},
+	}
+
+	for i, v := range tt {
+		err := lintStatement(v.stmt)
+		if len(v.token) == 0 {
+			if err != nil {
+				t.Errorf("%v: expected err to be nil, got %v", i, err)
+			}
+			continue
+		}
+		e, ok := err.(ErrForeignSQL)
+		if !ok {
+			t.Errorf("%v: expected ErrForeignSQL, got %v", i, err)
+			continue
+		}
+		if e.Token != v.token {
+			t.Errorf("%v: expected token %q, got %q", i, v.token, e.Token)
+		}
+	}
+}
+
+func TestRunLint(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?x-lint=on")
+	defer d.Close()
+
+	// nothing runs if any statement fails
+	err := d.Run(bytes.NewReader([]byte("CREATE TABLE foo (id int); CREATE TABLE bar (id SERIAL);")))
+	if _, ok := err.(ErrForeignSQL); !ok {
+		t.Fatalf("expected ErrForeignSQL, got %v", err)
+	}
+	if tableExists(t, d, "main", "foo") {
+		t.Fatalf("expected table foo not to exist")
+	}
+
+	if err := d.Run(bytes.NewReader([]byte("CREATE TABLE foo (id int);"))); err != nil {
+		t.Fatalf("expected err to be nil, got %v", err)
+	}
+}
+
+func TestValidateSQL(t *testing.T) {
+	valid := `CREATE TABLE foo (id int);
+		-- no tables exist without a database
+		INSERT INTO bar (baz) VALUES (1);
+		CREATE TRIGGER foo_insert AFTER INSERT ON foo BEGIN SELECT 1; END;
+		;`
+	if err := ValidateSQL(valid); err != nil {
+		t.Fatalf("expected valid SQL, got %v", err)
+	}
+
+	invalid := "CREATE TABLE foo (id int);\nSELECT 1;\n  INSRT INTO foo VALUES (1);\nSELEC 2;"
+	err := ValidateSQL(invalid)
+	e, ok := err.(ErrInvalidSQL)
+	if !ok {
+		t.Fatalf("expected ErrInvalidSQL, got %v", err)
+	}
+	if e.Statement != "INSRT INTO foo VALUES (1);" || e.Line != 3 || e.Column != 3 {
+		t.Fatalf("expected the statement at line 3, column 3, got %q at line %v, column %v", e.Statement, e.Line, e.Column)
+	}
+	if !strings.Contains(e.Error(), "syntax error") {
+		t.Fatalf("expected a syntax error, got %v", e)
+	}
+}
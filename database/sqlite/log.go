@@ -0,0 +1,76 @@
+package sqlite
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// LogLevel is the verbosity of the built-in logger, see Config.LogLevel.
+type LogLevel int
+
+const (
+	// LogSilent disables the built-in logger, the default.
+	LogSilent LogLevel = iota
+
+	// LogError logs failed migrations only.
+	LogError
+
+	// LogInfo logs a summary of every migration run.
+	LogInfo
+
+	// LogDebug logs every statement along with
+	// the driver's verbose output.
+	LogDebug
+)
+
+// logOutput is where the built-in logger writes to.
+var logOutput io.Writer = os.Stderr
+
+// parseLogLevel parses the value of x-log-level.
+func parseLogLevel(v string) (LogLevel, error) {
+	switch strings.ToLower(v) {
+	case "", "silent":
+		return LogSilent, nil
+	case "error":
+		return LogError, nil
+	case "info":
+		return LogInfo, nil
+	case "debug":
+		return LogDebug, nil
+	}
+	return LogSilent, fmt.Errorf("invalid log level %q", v)
+}
+
+// levelLogger is the built-in logger, it writes the messages
+// up to level to out.
+type levelLogger struct {
+	level LogLevel
+	out   *log.Logger
+}
+
+func newLevelLogger(level LogLevel, w io.Writer) *levelLogger {
+	return &levelLogger{level: level, out: log.New(w, "sqlite: ", log.LstdFlags)}
+}
+
+func (l *levelLogger) Printf(format string, v ...interface{}) {
+	l.out.Printf(format, v...)
+}
+
+func (l *levelLogger) Verbose() bool {
+	return l.level >= LogDebug
+}
+
+// logLevelPrintf logs a message of level. The built-in logger filters
+// messages by its level, other loggers get them if they are verbose.
+func (s *Sqlite) logLevelPrintf(level LogLevel, format string, v ...interface{}) {
+	if l, ok := s.config.Log.(*levelLogger); ok {
+		if level <= l.level {
+			l.Printf(format, v...)
+		}
+		return
+	}
+	s.logVerbosePrintf(format, v...)
+}
@@ -0,0 +1,62 @@
+package sqlite
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLogLevel(t *testing.T) {
+	tt := []struct {
+		level    string
+		expected []string
+		not      []string
+	}{
+		{"silent", []string{}, []string{"ran in", "failed", "exec:"}},
+		{"error", []string{"migration 2 failed"}, []string{"ran in", "exec:"}},
+		{"info", []string{"migration 1 ran in", "migration 2 failed"}, []string{"exec:"}},
+		{"debug", []string{"exec: CREATE TABLE foo (id int);", "SAVEPOINT txn_1", "migration 1 ran in", "migration 2 failed"}, []string{}},
+	}
+
+	defer func(w io.Writer) {
+		logOutput = w
+	}(logOutput)
+
+	for _, v := range tt {
+		dir, cleanup := tempDir(t)
+		defer cleanup()
+
+		var out bytes.Buffer
+		logOutput = &out
+		d := open(t, dir, "?x-log-level="+v.level)
+		defer d.Close()
+		if _, ok := d.config.Log.(*levelLogger); !ok && v.level != "silent" {
+			t.Fatalf("%v: expected the built-in logger, got %T", v.level, d.config.Log)
+		}
+
+		d.SetCurrentVersion(1)
+		if err := d.Run(bytes.NewReader([]byte(`CREATE TABLE foo (id int);`))); err != nil {
+			t.Fatal(err)
+		}
+		d.SetCurrentVersion(2)
+		if err := d.Run(bytes.NewReader([]byte(`CREATE TABLE foo (id int);`))); err == nil {
+			t.Fatalf("%v: expected table foo to exist already", v.level)
+		}
+
+		for _, line := range v.expected {
+			if !strings.Contains(out.String(), line) {
+				t.Errorf("%v: expected %q to be logged, got %q", v.level, line, out.String())
+			}
+		}
+		for _, line := range v.not {
+			if strings.Contains(out.String(), line) {
+				t.Errorf("%v: expected %q not to be logged, got %q", v.level, line, out.String())
+			}
+		}
+	}
+
+	if _, err := parseLogLevel("trace"); err == nil {
+		t.Errorf("expected an invalid log level to fail")
+	}
+}
@@ -0,0 +1,73 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/mattes/migrate/database"
+)
+
+// ManifestEntry is a row of the migrations table, see ExportManifest.
+type ManifestEntry struct {
+	Version int  `json:"version"`
+	Dirty   bool `json:"dirty"`
+
+	// Checksum is set in checksum mode
+	Checksum string `json:"checksum,omitempty"`
+
+	// AppliedAt is set in history mode
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// ExportManifest returns the rows of the migrations table as a JSON array
+// of ManifestEntry, ordered by version, to store the state of a
+// database along with a deployment. Without history mode there is one
+// entry at most.
+func (s *Sqlite) ExportManifest() (manifest []byte, err error) {
+	defer s.handleError(&err)
+
+	columns := `version, dirty`
+	if s.config.Checksum {
+		columns += `, checksum`
+	}
+	if s.config.History {
+		columns += `, applied_at`
+	}
+
+	query := `SELECT ` + columns + ` FROM ` + quoteIdentifier(s.config.MigrationsTable) + ` ORDER BY version`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defer rows.Close()
+
+	entries := make([]ManifestEntry, 0)
+	for rows.Next() {
+		var entry ManifestEntry
+		var sum sql.NullString
+		var appliedAt time.Time
+
+		dest := []interface{}{&entry.Version, &entry.Dirty}
+		if s.config.Checksum {
+			dest = append(dest, &sum)
+		}
+		if s.config.History {
+			dest = append(dest, &appliedAt)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+		}
+
+		entry.Checksum = sum.String
+		if s.config.History {
+			entry.AppliedAt = &appliedAt
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+
+	return json.Marshal(entries)
+}
@@ -0,0 +1,62 @@
+package sqlite
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestExportManifest(t *testing.T) {
+	tt := []struct {
+		query  string
+		fields []string
+		count  int
+	}{
+		{"", []string{"version", "dirty"}, 1},
+		{"?x-checksum=on", []string{"version", "dirty", "checksum"}, 1},
+		{"?x-history=on&x-checksum=on", []string{"version", "dirty", "checksum", "applied_at"}, 2},
+	}
+
+	for _, v := range tt {
+		dir, cleanup := tempDir(t)
+		d := open(t, dir, v.query)
+
+		for _, version := range []int{1, 2} {
+			if err := d.Run(bytes.NewReader([]byte(fmt.Sprintf("CREATE TABLE foo%v (foo text);", version)))); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.SetVersion(version, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		manifest, err := d.ExportManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entries []map[string]interface{}
+		if err := json.Unmarshal(manifest, &entries); err != nil {
+			t.Fatalf("%v: invalid JSON %s: %v", v.query, manifest, err)
+		}
+		if len(entries) != v.count {
+			t.Fatalf("%v: expected %v entries, got %s", v.query, v.count, manifest)
+		}
+
+		last := entries[len(entries)-1]
+		if len(last) != len(v.fields) {
+			t.Errorf("%v: expected fields %v, got %s", v.query, v.fields, manifest)
+		}
+		for _, field := range v.fields {
+			if _, ok := last[field]; !ok {
+				t.Errorf("%v: expected field %v, got %s", v.query, field, manifest)
+			}
+		}
+		if last["version"] != float64(2) || last["dirty"] != false {
+			t.Errorf("%v: expected clean version 2, got %s", v.query, manifest)
+		}
+
+		d.Close()
+		cleanup()
+	}
+}
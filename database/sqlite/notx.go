@@ -0,0 +1,63 @@
+package sqlite
+
+import (
+	"fmt"
+)
+
+// ErrNeedsNoTx is returned by Run if a statement can't run inside of
+// the migration's transaction and isn't marked with the migrate:no-tx
+// directive.
+type ErrNeedsNoTx struct {
+	Statement string
+	Reason    string
+}
+
+func (e ErrNeedsNoTx) Error() string {
+	return fmt.Sprintf("%v, put a %q line in front of it or set x-no-tx: %v", e.Reason, noTxDirective, e.Statement)
+}
+
+// needsNoTx returns why stmt can't run inside of a transaction, or an
+// empty string if it can. ATTACH and DETACH are left out, Run moves them
+// out of the transaction itself.
+func needsNoTx(stmt string) string {
+	tokens := tokenize(stmt)
+	if len(tokens) == 0 {
+		return ""
+	}
+
+	switch tokens[0] {
+	case "VACUUM":
+		return "VACUUM can't run inside of a transaction"
+
+	case "PRAGMA":
+		// skip the schema name of PRAGMA schema.name
+		name := tokens[1:]
+		if len(name) > 2 && name[1] == "." {
+			name = name[2:]
+		}
+		if len(name) == 0 {
+			return ""
+		}
+		assigned := len(name) > 1 && (name[1] == "=" || name[1] == "(")
+
+		// incremental_vacuum is left out on purpose: unlike VACUUM it
+		// frees pages inside of the transaction, so it runs as part of
+		// the migration and is rolled back with it
+		// https://www.sqlite.org/pragma.html#pragma_incremental_vacuum
+		switch name[0] {
+		case "WAL_CHECKPOINT":
+			// https://www.sqlite.org/pragma.html#pragma_wal_checkpoint
+			return "PRAGMA wal_checkpoint can't checkpoint while a transaction is open, it only reports busy"
+		case "JOURNAL_MODE":
+			if assigned {
+				return "PRAGMA journal_mode can't be changed inside of a transaction"
+			}
+		case "FOREIGN_KEYS":
+			// https://www.sqlite.org/pragma.html#pragma_foreign_keys
+			if assigned {
+				return "PRAGMA foreign_keys has no effect inside of a transaction"
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,395 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	nurl "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattes/migrate/database"
+	"github.com/mattn/go-sqlite3"
+)
+
+// journalModes are the valid values of Options.JournalMode.
+// https://www.sqlite.org/pragma.html#pragma_journal_mode
+var journalModes = map[string]bool{
+	"DELETE":   true,
+	"TRUNCATE": true,
+	"PERSIST":  true,
+	"MEMORY":   true,
+	"WAL":      true,
+	"OFF":      true,
+}
+
+// Options configures OpenWithOptions. The embedded Config is passed on
+// to WithInstance, the other fields configure the connection.
+type Options struct {
+	Config
+
+	// JournalMode sets PRAGMA journal_mode, e.g. WAL.
+	// SQLite's default is kept if empty.
+	JournalMode string
+
+	// EnforceJournalMode makes opening fail with ErrJournalMode if
+	// SQLite kept another journal mode than JournalMode, like WAL
+	// on a file system without shared memory.
+	EnforceJournalMode bool
+
+	// BusyTimeout is how long to wait for locks of other connections.
+	// go-sqlite3's default of 5 seconds applies if zero.
+	BusyTimeout time.Duration
+
+	// ForeignKeys enables or disables foreign key constraints.
+	// SQLite's default (disabled) is kept if nil.
+	ForeignKeys *bool
+
+	// UTCTimes returns DATETIME, TIMESTAMP and DATE columns in UTC,
+	// see NowExpr. go-sqlite3's _loc parameter can't be set along with it.
+	UTCTimes bool
+
+	// Key is the SQLCipher encryption key. It is passed to go-sqlite3
+	// builds with SQLCipher support as _pragma_key and never logged.
+	// Opening fails with ErrNoSQLCipher without SQLCipher support.
+	Key string
+
+	// RandomSeed replaces random() and randomblob() with functions
+	// returning the same sequence for the same seed on every connection,
+	// which makes test fixtures reproducible. SQLite's are used if nil.
+	RandomSeed *int64
+
+	// Immutable opens the file with SQLite's immutable parameter, for
+	// reference databases shipped read-only, which SQLite reads without
+	// locking or checking for changes. It implies Config.ReadOnly.
+	// https://www.sqlite.org/uri.html#uriimmutable
+	Immutable bool
+
+	// Params are passed on to go-sqlite3 as connection string parameters.
+	// https://github.com/mattn/go-sqlite3#connection-string
+	Params nurl.Values
+}
+
+// OpenWithOptions opens the database at path, configured by opts instead
+// of URL query parameters. Open translates its URL into Options
+// and calls OpenWithOptions.
+func OpenWithOptions(path string, opts Options) (database.Driver, error) {
+	// go-sqlite3 only fails once it connects, with "unable to open database file"
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		return nil, ErrPathIsDirectory
+	}
+
+	params := nurl.Values{}
+	for k, v := range opts.Params {
+		params[k] = v
+	}
+
+	if len(opts.JournalMode) > 0 {
+		if !journalModes[strings.ToUpper(opts.JournalMode)] {
+			return nil, fmt.Errorf("invalid journal mode %q", opts.JournalMode)
+		}
+		params.Set("_journal_mode", strings.ToUpper(opts.JournalMode))
+	}
+
+	if opts.BusyTimeout < 0 {
+		return nil, fmt.Errorf("invalid busy timeout %v", opts.BusyTimeout)
+	}
+	if opts.BusyTimeout > 0 {
+		params.Set("_busy_timeout", strconv.FormatInt(int64(opts.BusyTimeout/time.Millisecond), 10))
+	}
+
+	if opts.ForeignKeys != nil {
+		params.Set("_foreign_keys", strconv.FormatBool(*opts.ForeignKeys))
+	}
+
+	if opts.UTCTimes {
+		if loc := params.Get("_loc"); len(loc) > 0 && loc != "UTC" {
+			return nil, fmt.Errorf("_loc=%v conflicts with UTC times", loc)
+		}
+		params.Set("_loc", "UTC")
+	}
+
+	if len(opts.Key) > 0 {
+		params.Set("_pragma_key", opts.Key)
+	}
+
+	if opts.Immutable {
+		params.Set("mode", "ro")
+		params.Set("immutable", "1")
+	}
+
+	// as a URI filename, characters like ? in path aren't
+	// mistaken for the start of the parameters
+	dsn := fileURI(path)
+	if query := params.Encode(); len(query) > 0 {
+		dsn += "?" + query
+	}
+
+	config := opts.Config
+	if len(config.DatabaseName) == 0 {
+		config.DatabaseName = path
+	}
+	if opts.Immutable {
+		config.ReadOnly = true
+	}
+
+	// InitSQL runs on every connection, including ones database/sql
+	// opens to replace broken ones and the read-only one of Version
+	var initStmts []string
+	if len(config.InitSQL) > 0 {
+		var err error
+		if initStmts, err = (&Sqlite{config: &config}).initStatements(); err != nil {
+			return nil, err
+		}
+	}
+	seed := opts.RandomSeed
+	hook := func(conn *sqlite3.SQLiteConn) error {
+		if seed != nil {
+			if err := registerSeededRandom(conn, *seed); err != nil {
+				return err
+			}
+		}
+		for _, stmt := range initStmts {
+			if _, err := conn.Exec(stmt, nil); err != nil {
+				return fmt.Errorf("init sql %v: %v in %q", config.InitSQL, err, stmt)
+			}
+		}
+		return nil
+	}
+
+	var db *sql.DB
+	if seed != nil || len(initStmts) > 0 {
+		db = sql.OpenDB(newHookConnector(dsn, hook))
+	} else {
+		var err error
+		if db, err = sql.Open("sqlite3", dsn); err != nil {
+			return nil, err
+		}
+	}
+
+	// SQLite without SQLCipher ignores the key, which would
+	// leave the database unencrypted
+	if len(opts.Key) > 0 {
+		var version string
+		if err := db.QueryRow(`PRAGMA cipher_version`).Scan(&version); err != nil || len(version) == 0 {
+			db.Close()
+			return nil, ErrNoSQLCipher
+		}
+	}
+
+	sx, err := WithInstance(db, &config, withConnectHook(hook))
+	if err != nil {
+		db.Close()
+		if isCorrupt(err) {
+			if e, ok := err.(*database.Error); ok {
+				err = e.OrigErr
+			}
+			return nil, ErrCorrupt{Path: path, OrigErr: err}
+		}
+		return nil, err
+	}
+
+	// the read-only connection of Version needs the same parameters,
+	// such as the key of an encrypted database
+	readerParams := nurl.Values{}
+	for k, v := range params {
+		readerParams[k] = v
+	}
+	readerParams.Set("mode", "ro")
+	sx.(*Sqlite).readerDSN = fileURI(path) + "?" + readerParams.Encode()
+
+	if err := sx.(*Sqlite).logDSNPragmas(params); err != nil {
+		sx.(*Sqlite).close()
+		return nil, err
+	}
+
+	if opts.EnforceJournalMode && len(opts.JournalMode) > 0 {
+		s := sx.(*Sqlite)
+		mode, err := s.pragma("journal_mode")
+		if err == nil && !strings.EqualFold(mode, opts.JournalMode) {
+			err = ErrJournalMode{Requested: strings.ToLower(opts.JournalMode), Actual: mode}
+		}
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+	}
+
+	return sx, nil
+}
+
+// ErrJournalMode is returned by OpenWithOptions if the journal mode
+// couldn't be set and EnforceJournalMode is set.
+type ErrJournalMode struct {
+	Requested string
+	Actual    string
+}
+
+func (e ErrJournalMode) Error() string {
+	return fmt.Sprintf("journal mode %v couldn't be set, the database is in %v mode", e.Requested, e.Actual)
+}
+
+// OpenReadOnly opens the existing database at path read-only, for
+// inspection tools. Nothing is ever created or written: the file is opened
+// with mode=ro, the driver is in read-only mode (see Config.ReadOnly) and
+// ErrNoVersionTable is returned if the default migrations table is missing.
+func OpenReadOnly(path string) (database.Driver, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, ErrPathIsDirectory
+	}
+
+	db, err := sql.Open("sqlite3", readOnlyDSN(path))
+	if err != nil {
+		return nil, err
+	}
+
+	sx, err := WithInstance(db, &Config{DatabaseName: path, ReadOnly: true})
+	if err != nil {
+		db.Close()
+		if isCorrupt(err) {
+			if e, ok := err.(*database.Error); ok {
+				err = e.OrigErr
+			}
+			return nil, ErrCorrupt{Path: path, OrigErr: err}
+		}
+		return nil, err
+	}
+
+	s := sx.(*Sqlite)
+	exists, err := s.tableExists(s.config.MigrationsTable)
+	if err == nil && !exists {
+		err = ErrNoVersionTable
+	}
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Option configures a driver in WithInstance.
+type Option func(*Sqlite) error
+
+// WithMigrationsTablePrefix prepends prefix to the migrations table,
+// so per-tenant tables like tenant42_schema_migrations can share a
+// database. prefix may only contain letters, digits and underscores.
+func WithMigrationsTablePrefix(prefix string) Option {
+	return func(s *Sqlite) error {
+		if !isIdentifierFragment(prefix) {
+			return fmt.Errorf("invalid migrations table prefix %q", prefix)
+		}
+		s.config.MigrationsTable = prefix + s.config.MigrationsTable
+		return nil
+	}
+}
+
+// WithSplitter makes Run split migrations into statements using splitter,
+// instead of SmartSplitter or the StatementMarker of Config.
+func WithSplitter(splitter Splitter) Option {
+	return func(s *Sqlite) error {
+		if splitter == nil {
+			return fmt.Errorf("no splitter")
+		}
+		s.splitter = splitter
+		return nil
+	}
+}
+
+// WithSavepointPrefix names the savepoints of the driver's transactions
+// prefix_1, prefix_2 and so on instead of txn_1, which tells drivers
+// apart in logs. prefix may only contain letters, digits and underscores.
+func WithSavepointPrefix(prefix string) Option {
+	return func(s *Sqlite) error {
+		if !isIdentifierFragment(prefix) {
+			return fmt.Errorf("invalid savepoint prefix %q", prefix)
+		}
+		s.savepointPrefix = prefix
+		return nil
+	}
+}
+
+// WithMaxSavepointDepth makes transactions fail with ErrSavepointTooDeep
+// instead of opening savepoint depth+1, catching migrations nested by
+// accident. Depth is unlimited by default.
+func WithMaxSavepointDepth(depth int) Option {
+	return func(s *Sqlite) error {
+		if depth < 1 {
+			return fmt.Errorf("invalid savepoint depth %v", depth)
+		}
+		s.maxSavepoints = depth
+		return nil
+	}
+}
+
+// WithTimeNow sets the clock the driver writes timestamps with, such
+// as applied_at in history mode, so tests can use fixed times.
+// It defaults to time.Now.
+func WithTimeNow(now func() time.Time) Option {
+	return func(s *Sqlite) error {
+		if now == nil {
+			return fmt.Errorf("no clock")
+		}
+		s.now = now
+		return nil
+	}
+}
+
+// WithErrorHandler passes the errors returned by the driver's exported
+// methods to fn and returns what fn returns instead, for example to add a
+// request ID. Each error is passed once, when it leaves the driver, so fn
+// gets it as built by the driver, such as a database.Error, and methods
+// calling each other internally don't pass it twice. A nil fn leaves
+// errors as they are.
+func WithErrorHandler(fn func(error) error) Option {
+	return func(s *Sqlite) error {
+		s.errorHandler = fn
+		return nil
+	}
+}
+
+// handleError replaces *err with what the handler set by WithErrorHandler
+// returns for it, if there are both.
+func (s *Sqlite) handleError(err *error) {
+	if *err != nil && s.errorHandler != nil {
+		*err = s.errorHandler(*err)
+	}
+}
+
+// withConnectHook tells WithInstance that OpenWithOptions opened the
+// connections with hook, which runs InitSQL, and opens the read-only
+// connection of Version with it too.
+func withConnectHook(hook func(*sqlite3.SQLiteConn) error) Option {
+	return func(s *Sqlite) error {
+		s.connectHook = hook
+		return nil
+	}
+}
+
+// ReadOnly makes the driver read-only, e.g. to check the version of a
+// read replica. See Config.ReadOnly.
+func ReadOnly() Option {
+	return func(s *Sqlite) error {
+		s.config.ReadOnly = true
+		return nil
+	}
+}
+
+// isIdentifierFragment reports if v is a non-empty string
+// of letters, digits and underscores.
+func isIdentifierFragment(v string) bool {
+	if len(v) == 0 {
+		return false
+	}
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if !(c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')) {
+			return false
+		}
+	}
+	return true
+}
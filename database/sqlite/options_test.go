@@ -0,0 +1,625 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattes/migrate/database"
+)
+
+func TestWithMigrationsTablePrefix(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	// both drivers share config, options don't change it
+	path := filepath.Join(dir, "sqlite.db")
+	config := &Config{}
+	drivers := make([]*Sqlite, 0)
+	for _, prefix := range []string{"tenant1_", "tenant2_"} {
+		db, err := sql.Open("sqlite3", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := WithInstance(db, config, WithMigrationsTablePrefix(prefix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		drivers = append(drivers, d.(*Sqlite))
+	}
+	if len(config.MigrationsTable) > 0 {
+		t.Fatalf("expected config to be unchanged, got migrations table %v", config.MigrationsTable)
+	}
+
+	if err := drivers[0].SetVersion(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := drivers[1].SetVersion(2, true); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range []int{1, 2} {
+		version, dirty, err := drivers[i].Version()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != expected || dirty != (i == 1) {
+			t.Fatalf("tenant%v: expected version %v, got %v (dirty: %v)", i+1, expected, version, dirty)
+		}
+	}
+	for _, table := range []string{"tenant1_schema_migrations", "tenant2_schema_migrations"} {
+		if !tableExists(t, drivers[0], "main", table) {
+			t.Fatalf("expected table %v to exist", table)
+		}
+	}
+	if tableExists(t, drivers[0], "main", "schema_migrations") {
+		t.Fatalf("expected table schema_migrations not to exist")
+	}
+}
+
+func TestWithMigrationsTablePrefixInvalid(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	for _, prefix := range []string{"", "tenant-1", `x"; DROP TABLE foo; --`} {
+		db, err := sql.Open("sqlite3", filepath.Join(dir, "sqlite.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := WithInstance(db, &Config{}, WithMigrationsTablePrefix(prefix)); err == nil {
+			t.Fatalf("expected err not to be nil for prefix %q", prefix)
+		}
+		db.Close()
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "sqlite.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{}
+	d, err := WithInstance(db, config, ReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	s := d.(*Sqlite)
+	if config.ReadOnly {
+		t.Fatal("expected the option not to change config")
+	}
+
+	if tableExists(t, s, "main", "schema_migrations") {
+		t.Fatal("expected read-only driver not to create the migrations table")
+	}
+	version, _, err := s.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != -1 {
+		t.Fatalf("expected NilVersion, got %v", version)
+	}
+
+	if err := s.Lock(); err != ErrReadOnly {
+		t.Fatalf("Lock: expected ErrReadOnly, got %v", err)
+	}
+	if err := s.Run(bytes.NewReader([]byte("CREATE TABLE foo (foo text)"))); err != ErrReadOnly {
+		t.Fatalf("Run: expected ErrReadOnly, got %v", err)
+	}
+	if err := s.SetVersion(1, false); err != ErrReadOnly {
+		t.Fatalf("SetVersion: expected ErrReadOnly, got %v", err)
+	}
+	if err := s.Drop(); err != ErrReadOnly {
+		t.Fatalf("Drop: expected ErrReadOnly, got %v", err)
+	}
+	if locking, err := s.pragma("locking_mode"); err != nil || locking != "normal" {
+		t.Fatalf("expected locking_mode normal, got %v (%v)", locking, err)
+	}
+}
+
+func TestOpenWithOptions(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	foreignKeys := true
+	fromOptions, err := OpenWithOptions(filepath.Join(dir, "options.db"), Options{
+		Config: Config{
+			MigrationsTable: "foo_migrations",
+			History:         true,
+		},
+		JournalMode: "wal",
+		BusyTimeout: 1500 * time.Millisecond,
+		ForeignKeys: &foreignKeys,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fromOptions.Close()
+
+	fromURL, err := (&Sqlite{}).Open(fmt.Sprintf("sqlite3://%v?x-migrations-table=foo_migrations&x-history=on&_journal_mode=wal&_busy_timeout=1500&_foreign_keys=on",
+		filepath.Join(dir, "url.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fromURL.Close()
+
+	for _, d := range []*Sqlite{fromOptions.(*Sqlite), fromURL.(*Sqlite)} {
+		if d.config.MigrationsTable != "foo_migrations" || !d.config.History {
+			t.Errorf("%v: unexpected config %+v", d.config.DatabaseName, d.config)
+		}
+		for pragma, expected := range map[string]string{"journal_mode": "wal", "busy_timeout": "1500", "foreign_keys": "1"} {
+			if v, err := d.pragma(pragma); err != nil || v != expected {
+				t.Errorf("%v: expected %v %v, got %v (%v)", d.config.DatabaseName, pragma, expected, v, err)
+			}
+		}
+	}
+
+	if _, err := OpenWithOptions(filepath.Join(dir, "options.db"), Options{JournalMode: "fast"}); err == nil {
+		t.Error("expected invalid journal mode to fail")
+	}
+	if _, err := (&Sqlite{}).Open(fmt.Sprintf("sqlite3://%v?_journal_mode=fast", filepath.Join(dir, "url.db"))); err == nil {
+		t.Error("expected invalid journal mode to fail")
+	}
+}
+
+// sentinelSplitter splits migrations at "-- next".
+type sentinelSplitter struct{}
+
+func (sentinelSplitter) Split(migration string) ([]string, error) {
+	if strings.Contains(migration, "-- fail") {
+		return nil, fmt.Errorf("can't split")
+	}
+	return strings.Split(migration, "-- next"), nil
+}
+
+func TestWithSplitter(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "sqlite.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := WithInstance(db, &Config{}, WithSplitter(sentinelSplitter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	s := d.(*Sqlite)
+
+	// the semicolons don't matter, each statement is executed as a whole
+	migration := "CREATE TABLE foo (foo text) -- next INSERT INTO foo VALUES ('a;b') -- next INSERT INTO foo VALUES ('c')"
+	if err := s.Run(bytes.NewReader([]byte(migration))); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM foo`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 rows, got %v", count)
+	}
+
+	if err := s.Run(bytes.NewReader([]byte("SELECT 1 -- fail"))); err == nil {
+		t.Fatal("expected splitter error")
+	}
+
+	if _, err := WithInstance(db, &Config{}, WithSplitter(nil)); err == nil {
+		t.Fatal("expected nil splitter to fail")
+	}
+}
+
+func TestWithSavepointPrefix(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "sqlite.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := &testLogger{}
+	d, err := WithInstance(db, &Config{Log: log}, WithSavepointPrefix("billing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if err := d.Run(bytes.NewReader([]byte("CREATE TABLE foo (foo text);"))); err != nil {
+		t.Fatal(err)
+	}
+	if !log.contains("SAVEPOINT billing_1") || !log.contains("RELEASE billing_1") {
+		t.Fatalf("expected savepoint billing_1, got %q", log.lines)
+	}
+
+	for _, prefix := range []string{"", "my-app", "a b"} {
+		if _, err := WithInstance(db, &Config{}, WithSavepointPrefix(prefix)); err == nil {
+			t.Errorf("expected prefix %q to fail", prefix)
+		}
+	}
+}
+
+func TestEnforceJournalMode(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d, err := (&Sqlite{}).Open(fmt.Sprintf("sqlite3://%v?_journal_mode=wal&x-enforce-journal-mode=on", filepath.Join(dir, "sqlite.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	// in-memory databases fall back to the memory journal mode
+	d, err = OpenWithOptions(":memory:", Options{JournalMode: "WAL"})
+	if err != nil {
+		t.Fatalf("expected the fallback to be accepted, got %v", err)
+	}
+	d.Close()
+
+	_, err = OpenWithOptions(":memory:", Options{JournalMode: "WAL", EnforceJournalMode: true})
+	if err != (ErrJournalMode{Requested: "wal", Actual: "memory"}) {
+		t.Fatalf("expected ErrJournalMode, got %v", err)
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	// a database without migrations table
+	path := filepath.Join(dir, "plain.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE foo (foo text)`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if _, err := OpenReadOnly(path); err != ErrNoVersionTable {
+		t.Fatalf("expected ErrNoVersionTable, got %v", err)
+	}
+	plain, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	var count int
+	if err := plain.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = ?`, DefaultMigrationsTable).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatal("expected no migrations table to be created")
+	}
+
+	if _, err := OpenReadOnly(filepath.Join(dir, "missing.db")); err == nil {
+		t.Fatal("expected a missing database to fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "missing.db")); !os.IsNotExist(err) {
+		t.Fatal("expected no database file to be created")
+	}
+
+	// a migrated database
+	rw := open(t, dir, "")
+	if err := rw.SetVersion(3, false); err != nil {
+		t.Fatal(err)
+	}
+	rw.Close()
+
+	ro, err := OpenReadOnly(filepath.Join(dir, "sqlite.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	if version, dirty, err := ro.Version(); err != nil || version != 3 || dirty {
+		t.Fatalf("expected version 3, got %v, %v, %v", version, dirty, err)
+	}
+	if err := ro.SetVersion(4, false); err != ErrReadOnly {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+}
+
+func TestOpenImmutable(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	rw := open(t, dir, "")
+	if err := rw.Run(bytes.NewReader([]byte("CREATE TABLE foo (foo text); INSERT INTO foo VALUES ('bar');"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.SetVersion(3, false); err != nil {
+		t.Fatal(err)
+	}
+	rw.Close()
+
+	path := filepath.Join(dir, "sqlite.db")
+	d, err := (&Sqlite{}).Open("sqlite3://" + path + "?immutable=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	s := d.(*Sqlite)
+
+	if version, dirty, err := s.Version(); err != nil || version != 3 || dirty {
+		t.Fatalf("expected version 3, got %v %v (%v)", version, dirty, err)
+	}
+	var foo string
+	if err := s.db.QueryRow(`SELECT foo FROM foo`).Scan(&foo); err != nil || foo != "bar" {
+		t.Fatalf("expected to read bar, got %q (%v)", foo, err)
+	}
+
+	if err := s.Run(bytes.NewReader([]byte("INSERT INTO foo VALUES ('baz');"))); err != ErrReadOnly {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+	if _, err := s.db.Exec(`INSERT INTO foo VALUES ('baz')`); err == nil {
+		t.Fatal("expected writes to fail")
+	}
+
+	// a database without migrations table
+	plainPath := filepath.Join(dir, "plain.db")
+	plain, err := sql.Open("sqlite3", plainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plain.Exec(`CREATE TABLE foo (foo text)`); err != nil {
+		t.Fatal(err)
+	}
+	plain.Close()
+
+	p, err := OpenWithOptions(plainPath, Options{Immutable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if exists, err := p.(*Sqlite).TableExists(DefaultMigrationsTable); err != nil || exists {
+		t.Fatalf("expected no migrations table to be created, got %v (%v)", exists, err)
+	}
+}
+
+func TestKey(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "encrypted.db")
+	for _, url := range []string{"sqlite3://:s3cret@" + path, "sqlite3://" + path + "?x-key=s3cret"} {
+		d, err := (&Sqlite{}).Open(url)
+		if err == ErrNoSQLCipher {
+			// the key was picked up, but SQLCipher isn't available
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: %v", url, err)
+		}
+		d.Close()
+	}
+
+	_, err := (&Sqlite{}).Open("sqlite3://:s3cret@" + path + "?x-key=0th3r")
+	if err == nil {
+		t.Fatal("expected a key in the URL and x-key to conflict")
+	}
+	if strings.Contains(err.Error(), "s3cret") || strings.Contains(err.Error(), "0th3r") {
+		t.Fatalf("expected the error not to reveal the key, got %v", err)
+	}
+
+	// no password, no key
+	d, err := (&Sqlite{}).Open("sqlite3://user@" + filepath.Join(dir, "plain.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+}
+
+func TestWithErrorHandler(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "sqlite.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var handled []error
+	drv, err := WithInstance(db, &Config{}, WithErrorHandler(func(err error) error {
+		handled = append(handled, err)
+		return fmt.Errorf("request 42: %v", err)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer drv.Close()
+
+	if err := drv.Run(bytes.NewReader([]byte("CREATE TABLE foo (foo text);"))); err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 0 {
+		t.Fatalf("expected the handler not to be called, got %v", handled)
+	}
+
+	err = drv.Run(bytes.NewReader([]byte("SELECT * FROM missing;")))
+	if err == nil || !strings.HasPrefix(err.Error(), "request 42: ") {
+		t.Fatalf("expected the handler's error, got %v", err)
+	}
+	if len(handled) != 1 {
+		t.Fatalf("expected the handler to be called once, got %v", handled)
+	}
+	if _, ok := handled[0].(database.Error); !ok {
+		t.Fatalf("expected the handler to get a database.Error, got %T", handled[0])
+	}
+
+	// nil leaves errors as they are
+	other, err := WithInstance(db, &Config{}, WithErrorHandler(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := other.Run(bytes.NewReader([]byte("SELECT * FROM missing;"))).(database.Error); !ok {
+		t.Fatal("expected a database.Error")
+	}
+}
+
+func TestWithErrorHandlerOnce(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	wrap := func(err error) error {
+		return fmt.Errorf("request 42: %v", err)
+	}
+	leader := open(t, dir, "")
+	defer leader.Close()
+	follower := open(t, dir, "")
+	defer follower.Close()
+	follower.errorHandler = wrap
+
+	// WaitForUnlock still sees database.ErrLocked from its attempts and
+	// waits until ctx is done
+	if err := leader.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := follower.WaitForUnlock(ctx)
+	if err == nil || err.Error() != "request 42: "+context.DeadlineExceeded.Error() {
+		t.Fatalf("expected the handled context.DeadlineExceeded, got %v", err)
+	}
+	if err := leader.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	d := open(t, dir, "?x-version-directives=true")
+	defer d.Close()
+	d.errorHandler = wrap
+	err = d.Run(strings.NewReader("-- +version 1\nSELECT 1;\n-- +version 2\nSELECT * FROM missing;\n"))
+	if err == nil || strings.Count(err.Error(), "request 42: ") != 1 {
+		t.Fatalf("expected the error to be handled once, got %v", err)
+	}
+}
+
+func TestWithMaxSavepointDepth(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "sqlite.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv, err := WithInstance(db, &Config{}, WithMaxSavepointDepth(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer drv.Close()
+	d := drv.(*Sqlite)
+
+	var nest func(n int) error
+	nest = func(n int) error {
+		if n == 0 {
+			return nil
+		}
+		return d.transactionally(func() error {
+			return nest(n - 1)
+		})
+	}
+	if err := nest(2); err != nil {
+		t.Fatalf("expected depth 2 to be allowed, got %v", err)
+	}
+	if err := nest(3); err != ErrSavepointTooDeep {
+		t.Fatalf("expected ErrSavepointTooDeep, got %v", err)
+	}
+	if depth := d.SavepointDepth(); depth != 0 {
+		t.Fatalf("expected all savepoints to be released, got depth %v", depth)
+	}
+
+	// RunMany opens one savepoint, the migration another one
+	if err := d.RunMany(bytes.NewReader([]byte("CREATE TABLE foo (foo text);"))); err != nil {
+		t.Fatalf("expected RunMany to stay within depth 2, got %v", err)
+	}
+
+	if _, err := WithInstance(db, &Config{}, WithMaxSavepointDepth(0)); err == nil {
+		t.Errorf("expected depth 0 to fail")
+	}
+}
+
+func TestWithTimeNow(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "sqlite.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixed := time.Date(2017, 3, 14, 15, 9, 26, 0, time.FixedZone("NZDT", 13*60*60))
+	d, err := WithInstance(db, &Config{History: true}, WithTimeNow(func() time.Time { return fixed }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if err := d.SetVersion(1, false); err != nil {
+		t.Fatal(err)
+	}
+	appliedAt, err := d.(*Sqlite).LastAppliedAt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !appliedAt.Equal(fixed) {
+		t.Fatalf("expected applied_at %v, got %v", fixed, appliedAt)
+	}
+
+	if _, err := WithInstance(db, &Config{}, WithTimeNow(nil)); err == nil {
+		t.Fatal("expected a nil clock to fail")
+	}
+}
+
+func TestInitSQL(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	initSQL := filepath.Join(dir, "init.sql")
+	if err := ioutil.WriteFile(initSQL, []byte("-- helpers\nCREATE TEMP VIEW answer AS SELECT 42 AS value;\nCREATE TEMP TABLE scratch (value int);\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := open(t, dir, "?x-init-sql="+url.QueryEscape(initSQL))
+	defer d.Close()
+	var value int
+	if err := d.db.QueryRow(`SELECT value FROM answer`).Scan(&value); err != nil {
+		t.Fatal(err)
+	}
+	if value != 42 {
+		t.Fatalf("expected 42, got %v", value)
+	}
+
+	// connections opened later and the read-only one of Version
+	// have the view too
+	d.db.SetMaxIdleConns(0)
+	reader, err := d.readConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, db := range []*sql.DB{d.db, d.db, reader} {
+		if err := db.QueryRow(`SELECT value FROM answer`).Scan(&value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	broken := filepath.Join(dir, "broken.sql")
+	if err := ioutil.WriteFile(broken, []byte("CREATE TEMP VIEW v AS SELECT;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{broken, filepath.Join(dir, "missing.sql")} {
+		_, err := (&Sqlite{}).Open("sqlite3://" + filepath.Join(dir, "sqlite.db") + "?x-init-sql=" + url.QueryEscape(path))
+		if err == nil || !strings.Contains(err.Error(), path) {
+			t.Fatalf("expected an error naming %v, got %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package sqlite
+
+import (
+	"fmt"
+	"io/ioutil"
+	nurl "net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mattes/migrate/database"
+)
+
+// ErrVacuumRequired is returned if a pragma only takes effect on an
+// existing database after running VACUUM.
+type ErrVacuumRequired struct {
+	Pragma string
+}
+
+func (e ErrVacuumRequired) Error() string {
+	return fmt.Sprintf("%v can't be changed on an existing database, run VACUUM after setting it", e.Pragma)
+}
+
+// autoVacuumModes maps auto_vacuum modes to the values SQLite reports.
+var autoVacuumModes = map[string]string{
+	"NONE":        "0",
+	"FULL":        "1",
+	"INCREMENTAL": "2",
+}
+
+// applyPragmas sets the connection pragmas configured in Config. They are
+// set on the current connection of the driver only, connections opened
+// later, such as the one of readConn, start with SQLite's defaults.
+// https://www.sqlite.org/pragma.html
+func (s *Sqlite) applyPragmas() error {
+	if len(s.config.SecureDelete) > 0 {
+		value := strings.ToLower(s.config.SecureDelete)
+		switch value {
+		case "on", "off", "fast":
+		default:
+			return fmt.Errorf("invalid secure_delete %q, expected on, off or fast", s.config.SecureDelete)
+		}
+		if err := s.applyPragma("secure_delete", value); err != nil {
+			return err
+		}
+	}
+
+	if len(s.config.TempDir) > 0 {
+		if err := checkWritableDir(s.config.TempDir); err != nil {
+			return fmt.Errorf("invalid temp dir: %v", err)
+		}
+		if err := s.applyPragma("temp_store_directory", quoteLiteral(s.config.TempDir)); err != nil {
+			return err
+		}
+	}
+
+	if s.config.PageSize != nil {
+		size := *s.config.PageSize
+		if size < 512 || size > 65536 || size&(size-1) != 0 {
+			return fmt.Errorf("invalid page_size %v, expected a power of two between 512 and 65536", size)
+		}
+		value := strconv.FormatInt(size, 10)
+		err := s.setCreationPragma("page_size", value, value)
+		if e, ok := err.(ErrVacuumRequired); ok {
+			// keep working with the current page size
+			s.logVerbosePrintf("warning: %v\n", e)
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(s.config.AutoVacuum) > 0 {
+		mode := strings.ToUpper(s.config.AutoVacuum)
+		expected, ok := autoVacuumModes[mode]
+		if !ok {
+			return fmt.Errorf("invalid auto_vacuum %q, expected NONE, FULL or INCREMENTAL", s.config.AutoVacuum)
+		}
+		if err := s.setCreationPragma("auto_vacuum", mode, expected); err != nil {
+			return err
+		}
+	}
+
+	if s.config.JournalSizeLimit != nil {
+		if *s.config.JournalSizeLimit < -1 {
+			return fmt.Errorf("invalid journal_size_limit %v, expected -1 or more", *s.config.JournalSizeLimit)
+		}
+		if err := s.applyPragma("journal_size_limit", strconv.FormatInt(*s.config.JournalSizeLimit, 10)); err != nil {
+			return err
+		}
+	}
+
+	if s.config.Threads != nil {
+		if *s.config.Threads < 0 {
+			return fmt.Errorf("invalid threads %v, expected 0 or more", *s.config.Threads)
+		}
+		if err := s.applyPragma("threads", strconv.FormatInt(*s.config.Threads, 10)); err != nil {
+			return err
+		}
+	}
+
+	if s.config.WALAutocheckpoint != nil {
+		if *s.config.WALAutocheckpoint < 0 {
+			return fmt.Errorf("invalid wal_autocheckpoint %v, expected 0 or more", *s.config.WALAutocheckpoint)
+		}
+		if err := s.applyPragma("wal_autocheckpoint", strconv.FormatInt(*s.config.WALAutocheckpoint, 10)); err != nil {
+			return err
+		}
+	}
+
+	if s.config.RecursiveTriggers != nil {
+		value := "OFF"
+		if *s.config.RecursiveTriggers {
+			value = "ON"
+		}
+		if err := s.applyPragma("recursive_triggers", value); err != nil {
+			return err
+		}
+	}
+
+	if s.config.CellSizeCheck != nil {
+		value := "OFF"
+		if *s.config.CellSizeCheck {
+			value = "ON"
+		}
+		if err := s.applyPragma("cell_size_check", value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setCreationPragma sets a pragma that only takes effect before the first
+// table is created. For existing databases it returns ErrVacuumRequired,
+// unless the pragma is set to expected already.
+func (s *Sqlite) setCreationPragma(name, value, expected string) error {
+	empty, err := s.isEmpty()
+	if err != nil {
+		return err
+	}
+	if empty {
+		return s.applyPragma(name, value)
+	}
+
+	current, err := s.pragma(name)
+	if err != nil {
+		return err
+	}
+	if current != expected {
+		return ErrVacuumRequired{Pragma: name}
+	}
+	s.logVerbosePrintf("pragma %v reads back %v already\n", name, current)
+	return nil
+}
+
+// isEmpty reports if nothing has been written to the database yet.
+func (s *Sqlite) isEmpty() (bool, error) {
+	pages, err := s.pragma("page_count")
+	if err != nil {
+		return false, err
+	}
+	return pages == "0", nil
+}
+
+// applyPragma sets pragma name to value like setPragma and logs the
+// value SQLite reports afterwards, which shows if the setting took.
+func (s *Sqlite) applyPragma(name, value string) error {
+	if err := s.setPragma(name, value); err != nil {
+		return err
+	}
+	current, err := s.pragma(name)
+	if err != nil {
+		return err
+	}
+	s.logVerbosePrintf("pragma %v = %v, reads back %v\n", name, value, current)
+	return nil
+}
+
+// dsnPragmas are the pragmas go-sqlite3 sets from connection string
+// parameters, with the names of those parameters.
+// https://github.com/mattn/go-sqlite3#connection-string
+var dsnPragmas = []struct {
+	name   string
+	params []string
+}{
+	{"foreign_keys", []string{"_foreign_keys", "_fk"}},
+	{"busy_timeout", []string{"_busy_timeout", "_timeout"}},
+	{"journal_mode", []string{"_journal_mode", "_journal"}},
+}
+
+// logDSNPragmas logs the values SQLite reports for the pragmas set by
+// params, like applyPragma does for the pragmas of Config.
+func (s *Sqlite) logDSNPragmas(params nurl.Values) error {
+	if s.config.Log == nil || !s.config.Log.Verbose() {
+		return nil
+	}
+	for _, p := range dsnPragmas {
+		for _, param := range p.params {
+			value := params.Get(param)
+			if len(value) == 0 {
+				continue
+			}
+			current, err := s.pragma(p.name)
+			if err != nil {
+				return err
+			}
+			s.logVerbosePrintf("pragma %v = %v, reads back %v\n", p.name, value, current)
+			break
+		}
+	}
+	return nil
+}
+
+// setPragma sets pragma name to value. Pragmas don't accept
+// bound parameters, so value must have been validated before.
+func (s *Sqlite) setPragma(name, value string) error {
+	query := `PRAGMA ` + name + ` = ` + value
+	if _, err := s.db.Exec(query); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return nil
+}
+
+// pragma reads the value of pragma name.
+func (s *Sqlite) pragma(name string) (string, error) {
+	var value string
+	query := `PRAGMA ` + name
+	if err := s.db.QueryRow(query).Scan(&value); err != nil {
+		return "", &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return value, nil
+}
+
+// checkWritableDir returns an error unless dir is a directory
+// files can be created in.
+func checkWritableDir(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%v is not a directory", dir)
+	}
+	f, err := ioutil.TempFile(dir, "migrate")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,425 @@
+package sqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecureDelete(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?x-secure-delete=fast")
+	defer d.Close()
+
+	// 0 is off, 1 is on and 2 is fast
+	value, err := d.pragma("secure_delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "2" {
+		t.Fatalf("expected secure_delete to be 2 (fast), got %v", value)
+	}
+
+	p := &Sqlite{}
+	if _, err := p.Open("sqlite3://" + dir + "/invalid.db?x-secure-delete=maybe"); err == nil {
+		t.Fatal("expected err not to be nil")
+	}
+}
+
+func TestAutoVacuum(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?x-auto-vacuum=incremental")
+	value, err := d.pragma("auto_vacuum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "2" {
+		t.Fatalf("expected auto_vacuum to be 2 (incremental), got %v", value)
+	}
+	d.Close()
+
+	// re-open the existing database
+	d = open(t, dir, "?x-auto-vacuum=INCREMENTAL")
+	d.Close()
+
+	p := &Sqlite{}
+	_, err = p.Open("sqlite3://" + filepath.Join(dir, "sqlite.db") + "?x-auto-vacuum=FULL")
+	if _, ok := err.(ErrVacuumRequired); !ok {
+		t.Fatalf("expected ErrVacuumRequired, got %v", err)
+	}
+}
+
+func TestJournalSizeLimit(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	for _, limit := range []string{"1048576", "0", "-1"} {
+		d := open(t, dir, "?x-journal-size-limit="+limit)
+		value, err := d.pragma("journal_size_limit")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != limit {
+			t.Fatalf("expected journal_size_limit to be %v, got %v", limit, value)
+		}
+		d.Close()
+	}
+
+	p := &Sqlite{}
+	for _, limit := range []string{"-2", "1MB"} {
+		if _, err := p.Open("sqlite3://" + filepath.Join(dir, "sqlite.db") + "?x-journal-size-limit=" + limit); err == nil {
+			t.Fatalf("expected err not to be nil for %v", limit)
+		}
+	}
+}
+
+func TestThreads(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	for _, threads := range []string{"0", "2", "4"} {
+		d := open(t, dir, "?x-threads="+threads)
+		value, err := d.pragma("threads")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != threads {
+			t.Fatalf("expected threads to be %v, got %v", threads, value)
+		}
+		d.Close()
+	}
+
+	p := &Sqlite{}
+	for _, threads := range []string{"-1", "many"} {
+		if _, err := p.Open("sqlite3://" + filepath.Join(dir, "sqlite.db") + "?x-threads=" + threads); err == nil {
+			t.Fatalf("expected err not to be nil for %v", threads)
+		}
+	}
+}
+
+func TestWALAutocheckpoint(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	for _, pages := range []string{"0", "100", "10000"} {
+		d := open(t, dir, "?_journal_mode=wal&x-wal-autocheckpoint="+pages)
+		value, err := d.pragma("wal_autocheckpoint")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != pages {
+			t.Fatalf("expected wal_autocheckpoint to be %v, got %v", pages, value)
+		}
+		d.Close()
+	}
+
+	p := &Sqlite{}
+	for _, pages := range []string{"-1", "1k"} {
+		if _, err := p.Open("sqlite3://" + filepath.Join(dir, "sqlite.db") + "?x-wal-autocheckpoint=" + pages); err == nil {
+			t.Fatalf("expected err not to be nil for %v", pages)
+		}
+	}
+}
+
+func TestRecursiveTriggers(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	for query, expected := range map[string]string{"": "0", "?x-recursive-triggers=on": "1", "?x-recursive-triggers=off": "0"} {
+		d := open(t, dir, query)
+		value, err := d.pragma("recursive_triggers")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%v: expected recursive_triggers to be %v, got %v", query, expected, value)
+		}
+		d.Close()
+	}
+
+	// a trigger counting down fires recursively
+	d := open(t, dir, "?x-recursive-triggers=on")
+	defer d.Close()
+	migration := `CREATE TABLE countdown (n int);
+CREATE TRIGGER countdown_next AFTER INSERT ON countdown WHEN new.n > 0 BEGIN
+	INSERT INTO countdown VALUES (new.n - 1);
+END;
+INSERT INTO countdown VALUES (3);`
+	if err := d.Run(bytes.NewReader([]byte(migration))); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM countdown`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Fatalf("expected 4 rows, got %v", count)
+	}
+
+	if _, err := (&Sqlite{}).Open("sqlite3://" + filepath.Join(dir, "sqlite.db") + "?x-recursive-triggers=sometimes"); err == nil {
+		t.Fatal("expected err not to be nil")
+	}
+}
+
+func TestCellSizeCheck(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	for query, expected := range map[string]string{"": "0", "?x-cell-size-check=on": "1", "?x-cell-size-check=off": "0"} {
+		d := open(t, dir, query)
+		value, err := d.pragma("cell_size_check")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != expected {
+			t.Fatalf("%v: expected cell_size_check to be %v, got %v", query, expected, value)
+		}
+		d.Close()
+	}
+
+	if _, err := (&Sqlite{}).Open("sqlite3://" + filepath.Join(dir, "sqlite.db") + "?x-cell-size-check=2"); err == nil {
+		t.Fatal("expected err not to be nil")
+	}
+}
+
+func TestIgnoreCheckConstraints(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?x-ignore-check-constraints=on")
+	defer d.Close()
+
+	if err := d.Run(bytes.NewReader([]byte("CREATE TABLE foo (n int CHECK (n > 0));"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Run(bytes.NewReader([]byte("INSERT INTO foo VALUES (-1);"))); err == nil {
+		t.Fatal("expected the CHECK constraint to fail outside of Lock")
+	}
+
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Run(bytes.NewReader([]byte("INSERT INTO foo VALUES (-1);"))); err != nil {
+		t.Fatalf("expected the CHECK constraint to be ignored, got %v", err)
+	}
+	if err := d.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := d.pragma("ignore_check_constraints"); err != nil || value != "0" {
+		t.Fatalf("expected ignore_check_constraints to be restored, got %v (%v)", value, err)
+	}
+	var count int
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM foo`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 row, got %v", count)
+	}
+}
+
+func TestQueryOnly(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	if err := d.Run(bytes.NewReader([]byte("CREATE TABLE foo (n int); INSERT INTO foo VALUES (1);"))); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	d = open(t, dir, "?x-query-only=on")
+	defer d.Close()
+
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	assertion := "SELECT CASE WHEN (SELECT COUNT(*) FROM foo) = 1 THEN 1 ELSE abs(-9223372036854775808) END;"
+	if err := d.Run(bytes.NewReader([]byte(assertion))); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Run(bytes.NewReader([]byte("INSERT INTO foo VALUES (2);"))); err == nil {
+		t.Fatal("expected writing in query-only mode to fail")
+	}
+	if err := d.SetVersion(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := d.pragma("query_only"); err != nil || value != "0" {
+		t.Fatalf("expected query_only to be restored, got %v (%v)", value, err)
+	}
+	var count int
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM foo`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 row, got %v", count)
+	}
+}
+
+func TestLogPragmas(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "sqlite.db")+"?_journal_mode=wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	threads := int64(2)
+	recursiveTriggers := true
+	log := &testLogger{}
+	d, err := WithInstance(db, &Config{
+		SecureDelete:      "fast",
+		Threads:           &threads,
+		RecursiveTriggers: &recursiveTriggers,
+		Log:               log,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	for _, line := range []string{
+		"pragma secure_delete = fast, reads back 2",
+		"pragma threads = 2, reads back 2",
+		"pragma recursive_triggers = ON, reads back 1",
+		"pragma journal_mode reads back wal",
+	} {
+		if !log.contains(line) {
+			t.Errorf("expected %q to be logged, got %q", line, log.lines)
+		}
+	}
+}
+
+func TestLogDSNPragmas(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	foreignKeys := true
+	log := &testLogger{}
+	d, err := OpenWithOptions(filepath.Join(dir, "sqlite.db"), Options{
+		Config:      Config{Log: log},
+		JournalMode: "wal",
+		BusyTimeout: 2 * time.Second,
+		ForeignKeys: &foreignKeys,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	for _, line := range []string{
+		"pragma foreign_keys = true, reads back 1",
+		"pragma busy_timeout = 2000, reads back 2000",
+		"pragma journal_mode = WAL, reads back wal",
+	} {
+		if !log.contains(line) {
+			t.Errorf("expected %q to be logged, got %q", line, log.lines)
+		}
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?x-page-size=8192")
+	if value, err := d.pragma("page_size"); err != nil || value != "8192" {
+		t.Fatalf("expected page_size to be 8192, got %v (%v)", value, err)
+	}
+	d.Close()
+
+	// existing databases keep their page size
+	log := &testLogger{}
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "sqlite.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(1024)
+	e, err := WithInstance(db, &Config{PageSize: &size, Log: log})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	if value, err := e.(*Sqlite).pragma("page_size"); err != nil || value != "8192" {
+		t.Fatalf("expected page_size to stay 8192, got %v (%v)", value, err)
+	}
+	if !log.contains("VACUUM") {
+		t.Fatalf("expected a warning about VACUUM, got %q", log.lines)
+	}
+
+	p := &Sqlite{}
+	for _, size := range []string{"256", "1000", "131072", "4k"} {
+		if _, err := p.Open("sqlite3://" + filepath.Join(dir, "new.db") + "?x-page-size=" + size); err == nil {
+			t.Fatalf("expected err not to be nil for %v", size)
+		}
+	}
+}
+
+func TestTempDir(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("temp files are only visible in /proc/self/fd")
+	}
+
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	tmp := filepath.Join(dir, "tmp")
+	if err := os.Mkdir(tmp, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := (&Sqlite{}).Open("sqlite3://" + filepath.Join(dir, "sqlite.db") + "?x-temp-dir=" + filepath.Join(dir, "missing")); err == nil {
+		t.Fatalf("expected a missing temp dir to fail")
+	}
+
+	d := open(t, dir, "?x-temp-dir="+url.QueryEscape(tmp))
+	defer d.Close()
+	// the pragma applies to the whole process
+	defer d.setPragma("temp_store_directory", "''")
+
+	if value, err := d.pragma("temp_store_directory"); err != nil || value != tmp {
+		t.Fatalf("expected temp_store_directory %v, got %v (%v)", tmp, value, err)
+	}
+
+	// a sort too large for the cache spills to temp files, which are
+	// deleted right after they are opened and kept open while reading
+	if err := d.Run(bytes.NewReader([]byte(`PRAGMA cache_size = 10; PRAGMA temp_store = FILE;
+		CREATE TABLE foo AS WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 100000)
+		SELECT i, randomblob(100) AS b FROM n;`))); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := d.db.Query(`SELECT b FROM foo ORDER BY b`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+
+	fds, err := filepath.Glob("/proc/self/fd/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, fd := range fds {
+		if target, err := os.Readlink(fd); err == nil && strings.HasPrefix(target, tmp+string(filepath.Separator)) {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected temp files in %v", tmp)
+	}
+}
@@ -0,0 +1,100 @@
+package sqlite
+
+import (
+	"container/list"
+	"database/sql"
+
+	"github.com/mattes/migrate/database"
+)
+
+// DefaultPreparedCacheSize is the number of statements ExecPrepared
+// keeps prepared, unless Config.PreparedCacheSize is set.
+var DefaultPreparedCacheSize = 16
+
+// preparedStmt is an entry of the prepared statement cache.
+type preparedStmt struct {
+	query string
+	stmt  *sql.Stmt
+}
+
+// preparedCache is a least recently used cache of prepared statements,
+// keyed by their SQL text.
+type preparedCache struct {
+	size  int
+	order *list.List // most recently used first
+	stmts map[string]*list.Element
+}
+
+func newPreparedCache(size int) *preparedCache {
+	return &preparedCache{
+		size:  size,
+		order: list.New(),
+		stmts: make(map[string]*list.Element),
+	}
+}
+
+// get returns the prepared statement for query, nil if it isn't cached.
+func (c *preparedCache) get(query string) *sql.Stmt {
+	e, ok := c.stmts[query]
+	if !ok {
+		return nil
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*preparedStmt).stmt
+}
+
+// put caches stmt, closing the least recently used
+// statement if the cache is full.
+func (c *preparedCache) put(query string, stmt *sql.Stmt) {
+	c.stmts[query] = c.order.PushFront(&preparedStmt{query: query, stmt: stmt})
+	for c.order.Len() > c.size {
+		e := c.order.Back()
+		c.order.Remove(e)
+		p := e.Value.(*preparedStmt)
+		delete(c.stmts, p.query)
+		p.stmt.Close()
+	}
+}
+
+// clear closes and forgets all statements.
+func (c *preparedCache) clear() {
+	for e := c.order.Front(); e != nil; e = e.Next() {
+		e.Value.(*preparedStmt).stmt.Close()
+	}
+	c.order.Init()
+	c.stmts = make(map[string]*list.Element)
+}
+
+// ExecPrepared executes query with args, such as an INSERT of a data
+// migration run many times. The statement is prepared once and reused,
+// the most recently used statements are kept prepared until Close.
+func (s *Sqlite) ExecPrepared(query string, args ...interface{}) (err error) {
+	defer s.handleError(&err)
+
+	if s.config.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if s.prepared == nil {
+		size := s.config.PreparedCacheSize
+		if size <= 0 {
+			size = DefaultPreparedCacheSize
+		}
+		s.prepared = newPreparedCache(size)
+	}
+
+	stmt := s.prepared.get(query)
+	if stmt == nil {
+		s.logVerbosePrintf("prepare: %v\n", query)
+		var err error
+		if stmt, err = s.db.Prepare(query); err != nil {
+			return &database.Error{OrigErr: err, Query: []byte(query)}
+		}
+		s.prepared.put(query, stmt)
+	}
+
+	if _, err := stmt.Exec(args...); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package sqlite
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExecPrepared(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+	log := &testLogger{}
+	d.config.Log = log
+	d.config.PreparedCacheSize = 2
+
+	if err := d.Run(bytes.NewReader([]byte("CREATE TABLE foo (n int); CREATE TABLE bar (n int); CREATE TABLE baz (n int);"))); err != nil {
+		t.Fatal(err)
+	}
+
+	insertFoo := `INSERT INTO foo VALUES (?)`
+	for i := 0; i < 100; i++ {
+		if err := d.ExecPrepared(insertFoo, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if prepares := countLines(log, "prepare: "+insertFoo); prepares != 1 {
+		t.Fatalf("expected the statement to be prepared once, got %v", prepares)
+	}
+	var count int
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM foo`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 100 {
+		t.Fatalf("expected 100 rows, got %v", count)
+	}
+
+	// foo is evicted as the least recently used statement
+	for _, query := range []string{`INSERT INTO bar VALUES (?)`, `INSERT INTO baz VALUES (?)`, insertFoo} {
+		if err := d.ExecPrepared(query, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if prepares := countLines(log, "prepare: "+insertFoo); prepares != 2 {
+		t.Fatalf("expected the evicted statement to be prepared again, got %v", prepares)
+	}
+	if n := d.prepared.order.Len(); n != 2 {
+		t.Fatalf("expected 2 cached statements, got %v", n)
+	}
+
+	if err := d.ExecPrepared(`INSERT INTO missing VALUES (?)`, 1); err == nil {
+		t.Fatal("expected an invalid statement to fail")
+	}
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := d.prepared.order.Len(); n != 0 {
+		t.Fatalf("expected the cache to be cleared on Close, got %v statements", n)
+	}
+}
+
+// countLines returns the number of lines logged which are line.
+func countLines(log *testLogger, line string) int {
+	n := 0
+	for _, l := range log.lines {
+		if l == line+"\n" {
+			n++
+		}
+	}
+	return n
+}
+
+func BenchmarkExecPrepared(b *testing.B) {
+	dir, err := ioutil.TempDir("", "sqlite-driver-benchmark")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := &Sqlite{}
+	d, err := p.Open("sqlite3://" + filepath.Join(dir, "sqlite.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer d.Close()
+	s := d.(*Sqlite)
+
+	if err := s.Run(bytes.NewReader([]byte("CREATE TABLE foo (n int, name text);"))); err != nil {
+		b.Fatal(err)
+	}
+	if err := s.Lock(); err != nil {
+		b.Fatal(err)
+	}
+	defer s.Unlock()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := s.ExecPrepared(`INSERT INTO foo VALUES (?, ?)`, n, fmt.Sprintf("name %v", n)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+}
@@ -0,0 +1,32 @@
+package sqlite
+
+import (
+	"database/sql"
+
+	"github.com/mattes/migrate/database"
+)
+
+// Query runs a read query with args on the connection migrations run on
+// and calls fn for every row, e.g. to verify the data of a migration. While
+// locked, it reads the changes of the open transaction. fn scans the current
+// row, iteration stops at the first error it returns. The rows are closed
+// before Query returns.
+func (s *Sqlite) Query(query string, fn func(rows *sql.Rows) error, args ...interface{}) (err error) {
+	defer s.handleError(&err)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return nil
+}
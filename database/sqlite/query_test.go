@@ -0,0 +1,56 @@
+package sqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Run(bytes.NewReader([]byte(`CREATE TABLE foo (id int, name text); INSERT INTO foo VALUES (1, 'a'), (2, 'b'), (3, 'c');`))); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rows aren't committed yet
+	names := make([]string, 0)
+	err := d.Query(`SELECT name FROM foo WHERE id > ? ORDER BY id`, func(rows *sql.Rows) error {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		names = append(names, name)
+		return nil
+	}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"b", "c"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected %q, got %q", expected, names)
+	}
+
+	stop := fmt.Errorf("stop")
+	calls := 0
+	err = d.Query(`SELECT id FROM foo`, func(rows *sql.Rows) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Fatalf("expected to stop after the first row, got %v after %v calls", err, calls)
+	}
+
+	// the rows are closed, the connection can be used again
+	if err := d.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
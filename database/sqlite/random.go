@@ -0,0 +1,34 @@
+package sqlite
+
+import (
+	"math/rand"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// registerSeededRandom overrides the built-in random() and randomblob(N)
+// of conn with functions drawing from a pseudo-random generator seeded
+// with seed, so migrations using them produce the same data every run.
+// Every connection starts over with the same seed. Application-defined
+// functions take precedence over built-in ones.
+// https://www.sqlite.org/lang_corefunc.html#random
+func registerSeededRandom(conn *sqlite3.SQLiteConn, seed int64) error {
+	r := rand.New(rand.NewSource(seed))
+
+	err := conn.RegisterFunc("random", func() int64 {
+		return int64(r.Uint64())
+	}, false)
+	if err != nil {
+		return err
+	}
+
+	return conn.RegisterFunc("randomblob", func(n int64) []byte {
+		// like SQLite, return a single byte for N less than 1
+		if n < 1 {
+			n = 1
+		}
+		b := make([]byte, n)
+		r.Read(b)
+		return b
+	}, false)
+}
@@ -0,0 +1,54 @@
+package sqlite
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestRandomSeed(t *testing.T) {
+	fixture := func(seed string) []string {
+		dir, cleanup := tempDir(t)
+		defer cleanup()
+
+		d := open(t, dir, "?x-random-seed="+seed)
+		defer d.Close()
+		err := d.Run(bytes.NewReader([]byte(`CREATE TABLE foo AS
+			WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 5)
+			SELECT i, random() AS r, hex(randomblob(8)) AS b FROM n;`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rows, err := d.db.Query(`SELECT r, b FROM foo ORDER BY i`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		values := make([]string, 0)
+		for rows.Next() {
+			var r int64
+			var b string
+			if err := rows.Scan(&r, &b); err != nil {
+				t.Fatal(err)
+			}
+			values = append(values, fmt.Sprintf("%v %v", r, b))
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return values
+	}
+
+	first := fixture("42")
+	if len(first) != 5 {
+		t.Fatalf("expected 5 rows, got %q", first)
+	}
+	if second := fixture("42"); !reflect.DeepEqual(first, second) {
+		t.Fatalf("expected the same values for the same seed, got %q and %q", first, second)
+	}
+	if other := fixture("-7"); reflect.DeepEqual(first, other) {
+		t.Fatalf("expected other values for another seed, got %q", other)
+	}
+}
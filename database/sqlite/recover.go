@@ -0,0 +1,270 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mattes/migrate/database"
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrCorrupt is returned by Open if the database file is corrupt
+// or isn't a database at all. Recover may salvage its content.
+type ErrCorrupt struct {
+	Path    string
+	OrigErr error
+}
+
+func (e ErrCorrupt) Error() string {
+	return fmt.Sprintf("database %v is corrupt: %v", e.Path, e.OrigErr)
+}
+
+// Recover copies what is still readable of the corrupt database
+// into a new database at dst. See Sqlite.Recover. Databases with a
+// damaged header, like truncated files, can't be opened to recover them.
+func (e ErrCorrupt) Recover(dst string) error {
+	db, err := sql.Open("sqlite3", e.Path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	s := &Sqlite{db: db, config: &Config{}, currentVersion: database.NilVersion}
+	return s.Recover(dst)
+}
+
+// schemaObject is a row of sqlite_master.
+type schemaObject struct {
+	typ  string
+	name string
+	sql  string
+}
+
+// Recover copies what is still readable of a corrupt database into a new
+// database at dst, which must not exist. Tables are created and filled
+// first, then indexes, views and triggers are created. Objects which
+// can't be read or created are skipped, rows are copied until the first
+// unreadable one. The first error is returned once everything else has
+// been recovered. This is a last resort for databases SQLite reports as
+// malformed, check the result before using it.
+func (s *Sqlite) Recover(dst string) (err error) {
+	defer s.handleError(&err)
+
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("recover: %v already exists", dst)
+	}
+
+	out, err := sql.Open("sqlite3", dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	out.SetMaxOpenConns(1)
+	if err := out.Ping(); err != nil {
+		return err
+	}
+
+	// ignore errors in the schema itself as far as possible
+	s.db.Exec(`PRAGMA writable_schema = ON`)
+	defer s.db.Exec(`PRAGMA writable_schema = OFF`)
+
+	objects, err := s.schemaObjects()
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	skip := func(obj schemaObject, err error) {
+		s.logVerbosePrintf("recover: skipping %v %v: %v\n", obj.typ, obj.name, err)
+		if firstErr == nil {
+			firstErr = fmt.Errorf("recover %v %v: %v", obj.typ, obj.name, err)
+		}
+	}
+
+	// sqlite_sequence is created along with the first AUTOINCREMENT
+	// table, the internal tables are SQLite's business
+	tables := make(map[string]bool)
+	for _, obj := range objects {
+		if obj.typ != "table" || isInternalTable(obj.name) {
+			continue
+		}
+		if _, err := out.Exec(obj.sql); err != nil {
+			skip(obj, err)
+			continue
+		}
+		tables[strings.ToLower(obj.name)] = true
+		n, err := s.copyRows(out, obj.name)
+		s.logVerbosePrintf("recover: copied %v rows of table %v\n", n, obj.name)
+		if err != nil {
+			skip(obj, err)
+		}
+	}
+
+	if hasTable(objects, "sqlite_sequence") {
+		n, err := s.copySequences(out, tables)
+		s.logVerbosePrintf("recover: copied %v rows of table sqlite_sequence\n", n)
+		if err != nil {
+			skip(schemaObject{typ: "table", name: "sqlite_sequence"}, err)
+		}
+	}
+
+	for _, obj := range objects {
+		if obj.typ == "table" {
+			continue
+		}
+		if _, err := out.Exec(obj.sql); err != nil {
+			skip(obj, err)
+		}
+	}
+
+	return firstErr
+}
+
+// schemaObjects returns the tables, indexes, views and triggers of the
+// main database, tables first. Automatic indexes have no SQL and are
+// left out, they are created along with their tables.
+func (s *Sqlite) schemaObjects() ([]schemaObject, error) {
+	query := `SELECT type, name, sql FROM sqlite_master WHERE sql IS NOT NULL ORDER BY CASE type WHEN 'table' THEN 0 ELSE 1 END, rowid`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defer rows.Close()
+
+	objects := make([]schemaObject, 0)
+	for rows.Next() {
+		var obj schemaObject
+		if err := rows.Scan(&obj.typ, &obj.name, &obj.sql); err != nil {
+			return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+		}
+		objects = append(objects, obj)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return objects, nil
+}
+
+// hasTable reports if objects has a table called name.
+func hasTable(objects []schemaObject, name string) bool {
+	for _, obj := range objects {
+		if obj.typ == "table" && strings.EqualFold(obj.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// copySequences copies the AUTOINCREMENT counters of the tables in tables,
+// by lower case name, into sqlite_sequence of out. SQLite adds rows to it
+// as rows are inserted, so the rows of out are replaced rather than
+// copied, which would leave two rows for a table. It returns the number
+// of counters copied.
+// https://www.sqlite.org/autoinc.html
+func (s *Sqlite) copySequences(out *sql.DB, tables map[string]bool) (int, error) {
+	rows, err := s.db.Query(`SELECT name, seq FROM sqlite_sequence`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		var name string
+		var seq int64
+		if err = rows.Scan(&name, &seq); err != nil {
+			break
+		}
+		if !tables[strings.ToLower(name)] {
+			continue
+		}
+		if _, err = out.Exec(`DELETE FROM sqlite_sequence WHERE name = ?`, name); err != nil {
+			break
+		}
+		if _, err = out.Exec(`INSERT INTO sqlite_sequence (name, seq) VALUES (?, ?)`, name, seq); err != nil {
+			break
+		}
+		n++
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	return n, err
+}
+
+// copyRows copies the rows of table into the table of the same name in out,
+// until the first row that can't be read. It returns the number of rows copied.
+func (s *Sqlite) copyRows(out *sql.DB, table string) (int, error) {
+	tx, err := out.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := s.insertRows(tx.Prepare, table)
+
+	// keep the rows copied so far, even if reading failed
+	if cerr := tx.Commit(); cerr != nil {
+		return 0, cerr
+	}
+	return n, err
+}
+
+// insertRows inserts the rows of table with the INSERT statement
+// prepared by prepare, until the first row that can't be read or
+// inserted. It returns the number of rows inserted.
+func (s *Sqlite) insertRows(prepare func(string) (*sql.Stmt, error), table string) (int, error) {
+	query := `SELECT * FROM ` + quoteIdentifier(table)
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(columns)), ",")
+	insert, err := prepare(`INSERT INTO ` + quoteIdentifier(table) + ` VALUES (` + placeholders + `)`)
+	if err != nil {
+		return 0, err
+	}
+	defer insert.Close()
+
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	n := 0
+	for rows.Next() {
+		if err = rows.Scan(ptrs...); err != nil {
+			break
+		}
+		if _, err = insert.Exec(values...); err != nil {
+			break
+		}
+		n++
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	return n, err
+}
+
+// isCorrupt reports if err is caused by a malformed database file
+// or a file that isn't a database.
+func isCorrupt(err error) bool {
+	if e, ok := err.(*database.Error); ok {
+		err = e.OrigErr
+	}
+	if e, ok := err.(sqlite3.Error); ok {
+		return e.Code == sqlite3.ErrCorrupt || e.Code == sqlite3.ErrNotADB
+	}
+	return false
+}
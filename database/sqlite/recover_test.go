@@ -0,0 +1,117 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenCorrupt(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "sqlite.db")
+	junk := []byte("this is not a database, just some text padded to a page")
+	if err := ioutil.WriteFile(path, append(junk, make([]byte, 4096)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := (&Sqlite{}).Open(fmt.Sprintf("sqlite3://%v", path))
+	if _, ok := err.(ErrCorrupt); !ok {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	s := open(t, dir, "")
+	// the sequence of tags is ahead of its rows
+	if _, err := s.db.Exec(`CREATE TABLE tags (id integer primary key autoincrement, name text);
+INSERT INTO tags (name) VALUES ('a'), ('b'), ('c');
+DELETE FROM tags WHERE id = 3`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec(`CREATE TABLE foo (id integer primary key, body text)`); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2000; i++ {
+		if _, err := s.db.Exec(`INSERT INTO foo (body) VALUES (?)`, fmt.Sprintf("row %v with some padding to fill pages", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.db.Exec(`CREATE INDEX foo_body ON foo (body)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetVersion(3, false); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// overwrite one of the pages of table foo with garbage
+	path := filepath.Join(dir, "sqlite.db")
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	garbage := make([]byte, 4096)
+	for i := range garbage {
+		garbage[i] = 0xFF
+	}
+	if _, err := f.WriteAt(garbage, fi.Size()/4/4096*4096); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	d, err := (&Sqlite{}).Open(fmt.Sprintf("sqlite3://%v", path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = d.(*Sqlite)
+	defer s.Close()
+
+	dst := filepath.Join(dir, "recovered.db")
+	if err := s.Recover(dst); err == nil {
+		t.Fatal("expected an error about the unreadable rows")
+	}
+
+	out, err := sql.Open("sqlite3", dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	var n int
+	if err := out.QueryRow(`SELECT COUNT(*) FROM foo`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 || n >= 2000 {
+		t.Fatalf("expected some but not all rows to be recovered, got %v", n)
+	}
+	var version int
+	if err := out.QueryRow(`SELECT version FROM schema_migrations`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != 3 {
+		t.Fatalf("expected version 3, got %v", version)
+	}
+	if err := out.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'foo_body'`).Scan(&n); err != nil || n != 1 {
+		t.Fatalf("expected index foo_body to be recreated, got %v (%v)", n, err)
+	}
+	var seq int
+	if err := out.QueryRow(`SELECT COUNT(*), MAX(seq) FROM sqlite_sequence WHERE name = 'tags'`).Scan(&n, &seq); err != nil || n != 1 || seq != 3 {
+		t.Fatalf("expected one sqlite_sequence row of tags with seq 3, got %v rows with %v (%v)", n, seq, err)
+	}
+
+	if err := s.Recover(dst); err == nil {
+		t.Fatal("expected Recover to refuse an existing destination")
+	}
+}
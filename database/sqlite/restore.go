@@ -0,0 +1,106 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Restore replaces the content of the database with the database file src,
+// typically a snapshot taken before migrating, using the online backup API.
+// src is checked with PRAGMA quick_check first, ErrCorrupt is returned if
+// it isn't a sound database. The database is locked while it is restored,
+// unless it is locked already. Restore can't run inside of RunMany.
+func (s *Sqlite) Restore(src string) (err error) {
+	defer s.handleError(&err)
+
+	if s.config.ReadOnly {
+		return ErrReadOnly
+	}
+	if s.savepoints > 0 {
+		return ErrNoTxInTx
+	}
+
+	srcDB, err := sql.Open("sqlite3", readOnlyDSN(src))
+	if err != nil {
+		return err
+	}
+	defer srcDB.Close()
+	srcDB.SetMaxOpenConns(1)
+
+	if err := quickCheck(srcDB); err != nil {
+		if isCorrupt(err) {
+			return ErrCorrupt{Path: src, OrigErr: err}
+		}
+		return fmt.Errorf("restore %v: %v", src, err)
+	}
+
+	if !s.isLocked {
+		if err := s.lock(); err != nil {
+			return err
+		}
+		defer func() {
+			if uerr := s.unlock(); err == nil {
+				err = uerr
+			}
+		}()
+	}
+
+	// the backup API refuses to write to a connection inside of
+	// a transaction, the exclusive locking mode keeps the lock
+	err = s.outsideOfLockTx(func() error {
+		return s.backupFrom(srcDB)
+	})
+	if err != nil {
+		return err
+	}
+
+	// the snapshot may predate the migrations table
+	return s.ensureVersionTable()
+}
+
+// backupFrom copies the main database of src over the main database.
+func (s *Sqlite) backupFrom(src *sql.DB) error {
+	ctx := context.Background()
+	dstConn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return dstConn.Raw(func(dst interface{}) error {
+		return srcConn.Raw(func(src interface{}) error {
+			backup, err := dst.(*sqlite3.SQLiteConn).Backup("main", src.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// quickCheck returns an error if db isn't a sound database.
+// https://www.sqlite.org/pragma.html#pragma_quick_check
+func quickCheck(db *sql.DB) error {
+	var result string
+	query := `PRAGMA quick_check(1)`
+	if err := db.QueryRow(query).Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("quick check failed: %v", result)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package sqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestore(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	if err := d.Run(bytes.NewReader([]byte("CREATE TABLE foo (foo text); INSERT INTO foo VALUES ('before');"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetVersion(1, false); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := filepath.Join(dir, "snapshot.db")
+	if _, err := d.db.Exec(`VACUUM INTO ?`, snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Run(bytes.NewReader([]byte("UPDATE foo SET foo = 'after'; CREATE TABLE bar (bar text);"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetVersion(2, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	var foo string
+	if err := d.db.QueryRow(`SELECT foo FROM foo`).Scan(&foo); err != nil {
+		t.Fatal(err)
+	}
+	if foo != "before" {
+		t.Fatalf("expected foo to be restored, got %q", foo)
+	}
+	if exists, err := d.TableExists("bar"); err != nil || exists {
+		t.Fatalf("expected bar to be gone, got %v, %v", exists, err)
+	}
+	if version, dirty, err := d.Version(); err != nil || version != 1 || dirty {
+		t.Fatalf("expected version 1, got %v, %v, %v", version, dirty, err)
+	}
+
+	// restoring while locked keeps the lock
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if !d.isLocked {
+		t.Fatal("expected database to be locked")
+	}
+	if err := d.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	garbage := filepath.Join(dir, "garbage.db")
+	if err := ioutil.WriteFile(garbage, bytes.Repeat([]byte("garbage!"), 1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Restore(garbage); err == nil {
+		t.Fatal("expected restoring garbage to fail")
+	} else if _, ok := err.(ErrCorrupt); !ok {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+	if err := d.Restore(filepath.Join(dir, "missing.db")); err == nil {
+		t.Fatal("expected restoring a missing file to fail")
+	}
+}
+
+func TestRestoreUnlockError(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	// a snapshot passing quick_check, but not integrity_check
+	snapshot := filepath.Join(dir, "snapshot.db")
+	db, err := sql.Open("sqlite3", snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	for _, query := range []string{
+		`CREATE TABLE foo (foo int, bar int)`,
+		`CREATE INDEX foo_bar ON foo (bar)`,
+		`INSERT INTO foo VALUES (1, 2)`,
+		`PRAGMA writable_schema = ON`,
+		`UPDATE sqlite_master SET sql = 'CREATE INDEX foo_bar ON foo (foo)' WHERE name = 'foo_bar'`,
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	d := open(t, dir, "?x-integrity-check=full")
+	defer d.Close()
+
+	if _, ok := d.Restore(snapshot).(ErrIntegrityCheck); !ok {
+		t.Fatal("expected the error of Unlock")
+	}
+}
@@ -0,0 +1,74 @@
+package sqlite
+
+import (
+	"fmt"
+)
+
+// ErrDestructive is returned by Run in safe mode if a statement drops
+// tables or deletes all rows of a table, unless destructive statements
+// are allowed.
+type ErrDestructive struct {
+	Statement string
+}
+
+func (e ErrDestructive) Error() string {
+	return fmt.Sprintf("refusing destructive statement in safe mode: %v", e.Statement)
+}
+
+// isDestructive reports if stmt is a DROP TABLE, DROP DATABASE or TRUNCATE
+// statement, or a DELETE statement without a WHERE clause, which may follow
+// a WITH clause. A WHERE of a subquery doesn't count. Keywords inside of
+// string literals, quoted identifiers and comments are ignored.
+func isDestructive(stmt string) bool {
+	tokens := skipWith(tokenize(stmt))
+	if len(tokens) == 0 {
+		return false
+	}
+
+	switch tokens[0] {
+	case "DROP":
+		return len(tokens) > 1 && (tokens[1] == "TABLE" || tokens[1] == "DATABASE")
+
+	case "TRUNCATE":
+		return true
+
+	case "DELETE":
+		depth := 0
+		for _, token := range tokens {
+			switch {
+			case token == "(":
+				depth++
+			case token == ")":
+				depth--
+			case token == "WHERE" && depth == 0:
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// skipWith returns the tokens of a statement following its WITH clause,
+// if it has one. The common table expressions are in parentheses, so the
+// statement starts at the first keyword outside of them.
+// https://www.sqlite.org/lang_with.html
+func skipWith(tokens []string) []string {
+	if len(tokens) == 0 || tokens[0] != "WITH" {
+		return tokens
+	}
+	depth := 0
+	for i, token := range tokens {
+		switch token {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case "SELECT", "VALUES", "INSERT", "REPLACE", "UPDATE", "DELETE":
+			if depth == 0 {
+				return tokens[i:]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package sqlite
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mattes/migrate/database"
+)
+
+func TestIsDestructive(t *testing.T) {
+	tt := []struct {
+		stmt     string
+		expected bool
+	}{
+		{"DROP TABLE foo", true},
+		{"-- cleanup\ndrop table if exists foo;", true},
+		{"DROP DATABASE foo", true},
+		{"TRUNCATE foo", true},
+		{"DELETE FROM foo", true},
+		{"DELETE FROM foo WHERE id = 1", false},
+		{"delete from foo where id in (select id from bar)", false},
+		{"WITH old AS (SELECT id FROM foo) DELETE FROM foo", true},
+		{"WITH RECURSIVE old (id) AS (SELECT 1 WHERE 1) DELETE FROM foo WHERE id IN old", false},
+		{"DELETE FROM foo RETURNING (SELECT max(id) FROM bar WHERE bar.id = 1)", true},
+		{"DELETE FROM foo WHERE (id = 1)", false},
+		{"DELETE FROM foo -- WHERE id = 1", true},
+		{"DROP INDEX foo_idx", false},
+		{"DROP VIEW foo", false},
+		{"INSERT INTO foo VALUES ('DROP TABLE foo; DELETE FROM foo')", false},
+		{`SELECT "DELETE FROM foo"`, false},
+		{"CREATE TRIGGER t AFTER INSERT ON a BEGIN DELETE FROM b; END", false},
+		{"", false},
+	}
+
+	for i, v := range tt {
+		if destructive := isDestructive(v.stmt); destructive != v.expected {
+			t.Errorf("%v: expected %v, got %v for %q", i, v.expected, destructive, v.stmt)
+		}
+	}
+}
+
+func TestRunSafeMode(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?x-safe-mode=on")
+	if err := d.Run(bytes.NewReader([]byte("CREATE TABLE foo (foo text); INSERT INTO foo VALUES ('DROP TABLE foo');"))); err != nil {
+		t.Fatal(err)
+	}
+	err := d.Run(bytes.NewReader([]byte("INSERT INTO foo VALUES ('bar'); DROP TABLE foo;")))
+	if _, ok := err.(ErrDestructive); !ok {
+		t.Fatalf("expected ErrDestructive, got %v", err)
+	}
+	var count int
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM foo`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected no statement to run, got %v rows", count)
+	}
+	d.Close()
+
+	d = open(t, dir, "?x-safe-mode=on&x-allow-destructive=on")
+	defer d.Close()
+	if err := d.Run(bytes.NewReader([]byte("DROP TABLE foo;"))); err != nil {
+		t.Fatal(err)
+	}
+	if tableExists(t, d, "main", "foo") {
+		t.Fatal("expected table foo to be dropped")
+	}
+}
+
+func TestSafeModeDowngrade(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?x-safe-mode=on")
+	if err := d.SetVersion(2, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetVersion(3, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetVersion(3, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range []int{2, database.NilVersion} {
+		if err := d.SetVersion(version, true); err != ErrDowngradeBlocked {
+			t.Fatalf("expected ErrDowngradeBlocked for version %v, got %v", version, err)
+		}
+	}
+	if version, _, err := d.Version(); err != nil || version != 3 {
+		t.Fatalf("expected version 3, got %v (%v)", version, err)
+	}
+	d.Close()
+
+	d = open(t, dir, "?x-safe-mode=on&x-allow-downgrade=on")
+	defer d.Close()
+	if err := d.SetVersion(2, false); err != nil {
+		t.Fatal(err)
+	}
+	if version, _, err := d.Version(); err != nil || version != 2 {
+		t.Fatalf("expected version 2, got %v (%v)", version, err)
+	}
+}
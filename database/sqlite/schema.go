@@ -0,0 +1,378 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mattes/migrate/database"
+)
+
+// isInternalTable reports if name is reserved for SQLite's internal tables.
+// https://www.sqlite.org/fileformat2.html#intschema
+func isInternalTable(name string) bool {
+	return strings.HasPrefix(strings.ToLower(name), "sqlite_")
+}
+
+// DropTable drops table name if it exists. It refuses to drop the
+// migrations table and SQLite's internal tables.
+func (s *Sqlite) DropTable(name string) (err error) {
+	defer s.handleError(&err)
+
+	if s.config.ReadOnly {
+		return ErrReadOnly
+	}
+	if len(name) == 0 {
+		return fmt.Errorf("no table name")
+	}
+	if isInternalTable(name) {
+		return fmt.Errorf("can't drop internal table %q", name)
+	}
+	if strings.EqualFold(name, s.config.MigrationsTable) {
+		return fmt.Errorf("can't drop migrations table %q", name)
+	}
+
+	return s.transactionally(func() error {
+		query := `DROP TABLE IF EXISTS ` + quoteIdentifier(name)
+		if _, err := s.db.Exec(query); err != nil {
+			return &database.Error{OrigErr: err, Query: []byte(query)}
+		}
+		return nil
+	})
+}
+
+// TableExists reports if table name exists. SQLite's internal
+// tables are never reported.
+func (s *Sqlite) TableExists(name string) (exists bool, err error) {
+	defer s.handleError(&err)
+	return s.tableExists(name)
+}
+
+func (s *Sqlite) tableExists(name string) (bool, error) {
+	if isInternalTable(name) {
+		return false, nil
+	}
+
+	var count int
+	query := `SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = ? COLLATE NOCASE`
+	if err := s.db.QueryRow(query, name).Scan(&count); err != nil {
+		return false, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return count > 0, nil
+}
+
+// ColumnExists reports if table has a column named column.
+// It returns false if table doesn't exist.
+func (s *Sqlite) ColumnExists(table, column string) (exists bool, err error) {
+	defer s.handleError(&err)
+	return s.columnExists(table, column)
+}
+
+func (s *Sqlite) columnExists(table, column string) (bool, error) {
+	if isInternalTable(table) {
+		return false, nil
+	}
+
+	var count int
+	query := `SELECT COUNT(1) FROM pragma_table_info(?) WHERE name = ? COLLATE NOCASE`
+	if err := s.db.QueryRow(query, table, column).Scan(&count); err != nil {
+		return false, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return count > 0, nil
+}
+
+// ForEachTable calls fn for every table in name order, except for the
+// migrations table and SQLite's internal tables. It stops at the first
+// error fn returns and returns it. The tables are read before fn is
+// called, so fn can query the database.
+func (s *Sqlite) ForEachTable(fn func(name string) error) (err error) {
+	defer s.handleError(&err)
+
+	query := `SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return &database.Error{OrigErr: err, Query: []byte(query)}
+		}
+		if !isInternalTable(name) && !strings.EqualFold(name, s.config.MigrationsTable) {
+			names = append(names, name)
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+
+	for _, name := range names {
+		if err := fn(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DumpSchema writes the statements creating the tables, indexes, views and
+// triggers of the database to w, each terminated by a semicolon and a
+// newline, so the output can be run as a migration or kept
+// under version control. Data, the migrations table and SQLite's internal
+// tables are left out. Tables come first, then indexes, views and triggers,
+// each in name order, except for views which keep the order they were
+// created in, as they may select from each other.
+func (s *Sqlite) DumpSchema(w io.Writer) (err error) {
+	defer s.handleError(&err)
+
+	query := `SELECT type, name, tbl_name, sql FROM sqlite_master WHERE sql IS NOT NULL
+		ORDER BY CASE type WHEN 'table' THEN 0 WHEN 'index' THEN 1 WHEN 'view' THEN 2 ELSE 3 END,
+			CASE type WHEN 'view' THEN rowid END, name`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var typ, name, table, stmt string
+		if err := rows.Scan(&typ, &name, &table, &stmt); err != nil {
+			return &database.Error{OrigErr: err, Query: []byte(query)}
+		}
+		if isInternalTable(table) || strings.EqualFold(table, s.config.MigrationsTable) {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%v;\n", stmt); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return nil
+}
+
+// FKViolation is a row violating a foreign key constraint,
+// see CheckForeignKeys.
+type FKViolation struct {
+	Table string
+
+	// RowID is the rowid of the row, nil for WITHOUT ROWID tables
+	RowID *int64
+
+	// Parent is the table referenced by the foreign key
+	Parent string
+
+	// FKID is the id of the foreign key in PRAGMA foreign_key_list
+	FKID int
+}
+
+// CheckForeignKeys returns the rows violating foreign key constraints,
+// such as orphans left by a migration run with foreign keys disabled.
+// https://www.sqlite.org/pragma.html#pragma_foreign_key_check
+func (s *Sqlite) CheckForeignKeys() (violations []FKViolation, err error) {
+	defer s.handleError(&err)
+
+	query := `PRAGMA foreign_key_check`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defer rows.Close()
+
+	violations = make([]FKViolation, 0)
+	for rows.Next() {
+		var v FKViolation
+		var rowID sql.NullInt64
+		if err := rows.Scan(&v.Table, &rowID, &v.Parent, &v.FKID); err != nil {
+			return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+		}
+		if rowID.Valid {
+			v.RowID = &rowID.Int64
+		}
+		violations = append(violations, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return violations, nil
+}
+
+// dropOrder sorts tables so tables referencing others with foreign keys
+// come before the tables they reference. Tables referencing each other
+// keep their order and are put last.
+func (s *Sqlite) dropOrder(tables []string) ([]string, error) {
+	// referrers counts the other tables referencing a table
+	referrers := make(map[string]int, len(tables))
+	parents := make(map[string][]string, len(tables))
+	for _, table := range tables {
+		query := `SELECT DISTINCT "table" FROM pragma_foreign_key_list(?)`
+		rows, err := s.db.Query(query, table)
+		if err != nil {
+			return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+		}
+		for rows.Next() {
+			var parent string
+			if err := rows.Scan(&parent); err != nil {
+				rows.Close()
+				return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+			}
+			parent = strings.ToLower(parent)
+			if parent != strings.ToLower(table) {
+				parents[table] = append(parents[table], parent)
+				referrers[parent]++
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, &database.Error{OrigErr: err, Query: []byte(query)}
+		}
+	}
+
+	ordered := make([]string, 0, len(tables))
+	done := make(map[string]bool, len(tables))
+	for len(ordered) < len(tables) {
+		progress := false
+		for _, table := range tables {
+			if done[table] || referrers[strings.ToLower(table)] > 0 {
+				continue
+			}
+			ordered = append(ordered, table)
+			done[table] = true
+			progress = true
+			for _, parent := range parents[table] {
+				referrers[parent]--
+			}
+		}
+		if !progress {
+			for _, table := range tables {
+				if !done[table] {
+					ordered = append(ordered, table)
+				}
+			}
+			break
+		}
+	}
+	return ordered, nil
+}
+
+// ErrIncompatibleVersionTable is returned if the migrations table exists
+// but can't be used by the driver, e.g. a table of another tool.
+type ErrIncompatibleVersionTable struct {
+	Table  string
+	Reason string
+}
+
+func (e ErrIncompatibleVersionTable) Error() string {
+	return fmt.Sprintf("incompatible migrations table %v: %v", e.Table, e.Reason)
+}
+
+// tableColumn is a row of PRAGMA table_info.
+type tableColumn struct {
+	name       string
+	notNull    bool
+	hasDefault bool
+	pk         int
+}
+
+// checkVersionTable returns ErrIncompatibleVersionTable if the existing
+// migrations table lacks columns the driver reads or writes, has other
+// columns the driver's inserts would fail on, or, unless in history mode,
+// allows more than one row per version.
+func (s *Sqlite) checkVersionTable() error {
+	table := s.config.MigrationsTable
+	query := `SELECT name, "notnull", dflt_value IS NOT NULL, pk FROM pragma_table_info(?)`
+	rows, err := s.db.Query(query, table)
+	if err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	columns := make(map[string]tableColumn)
+	for rows.Next() {
+		var c tableColumn
+		if err := rows.Scan(&c.name, &c.notNull, &c.hasDefault, &c.pk); err != nil {
+			rows.Close()
+			return &database.Error{OrigErr: err, Query: []byte(query)}
+		}
+		columns[strings.ToLower(c.name)] = c
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+
+	known := map[string]bool{"version": true, "dirty": true, "checksum": true}
+	required := []string{"version", "dirty"}
+	if s.config.History {
+		known["applied_at"] = true
+		required = append(required, "applied_at")
+	}
+	for _, name := range required {
+		if _, ok := columns[name]; !ok {
+			return ErrIncompatibleVersionTable{Table: table, Reason: fmt.Sprintf("no %v column", name)}
+		}
+	}
+	for name, c := range columns {
+		if !known[name] && c.notNull && !c.hasDefault && c.pk == 0 {
+			return ErrIncompatibleVersionTable{Table: table, Reason: fmt.Sprintf("column %v is NOT NULL without a default", c.name)}
+		}
+	}
+
+	if s.config.History {
+		return nil
+	}
+
+	// setVersion upserts on the version column
+	unique, err := s.versionUnique()
+	if err != nil {
+		return err
+	}
+	if !unique {
+		return ErrIncompatibleVersionTable{Table: table, Reason: "version is neither the primary key nor unique, is it a history table?"}
+	}
+	return nil
+}
+
+// versionUnique reports if the version column is the primary key
+// of the migrations table or has a unique index.
+func (s *Sqlite) versionUnique() (bool, error) {
+	table := s.config.MigrationsTable
+	var pks, versionPK int
+	query := `SELECT COUNT(1), COALESCE(SUM(name = 'version' COLLATE NOCASE), 0) FROM pragma_table_info(?) WHERE pk > 0`
+	if err := s.db.QueryRow(query, table).Scan(&pks, &versionPK); err != nil {
+		return false, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	if pks == 1 && versionPK == 1 {
+		return true, nil
+	}
+
+	var unique int
+	query = `SELECT COUNT(1) FROM pragma_index_list(?) AS l WHERE l."unique" = 1
+		AND (SELECT COUNT(1) FROM pragma_index_info(l.name)) = 1
+		AND (SELECT name FROM pragma_index_info(l.name)) = 'version' COLLATE NOCASE`
+	if err := s.db.QueryRow(query, table).Scan(&unique); err != nil {
+		return false, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return unique > 0, nil
+}
+
+// ensureVersionIndex adds a unique index on the version column to
+// history tables created without one, so a version can't be recorded
+// twice. It fails if the table has duplicate versions already.
+func (s *Sqlite) ensureVersionIndex() error {
+	unique, err := s.versionUnique()
+	if err != nil || unique {
+		return err
+	}
+	table := s.config.MigrationsTable
+	query := `CREATE UNIQUE INDEX ` + quoteIdentifier(table+"_version") + ` ON ` + quoteIdentifier(table) + ` (version)`
+	if _, err := s.db.Exec(query); err != nil {
+		return &database.Error{OrigErr: err, Err: "can't make versions unique", Query: []byte(query)}
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package sqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTableAndColumnExists(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+	if err := d.Run(bytes.NewReader([]byte(`CREATE TABLE "foo bar" (id int, "my ""col""" text); CREATE TABLE seq (id integer primary key autoincrement);`))); err != nil {
+		t.Fatal(err)
+	}
+
+	tables := []struct {
+		name   string
+		exists bool
+	}{
+		{"foo bar", true},
+		{"FOO BAR", true},
+		{"seq", true},
+		{"schema_migrations", true},
+		{"sqlite_master", false},
+		{"sqlite_sequence", false},
+		{"missing", false},
+		{"", false},
+	}
+	for _, v := range tables {
+		exists, err := d.TableExists(v.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != v.exists {
+			t.Errorf("TableExists(%q): expected %v, got %v", v.name, v.exists, exists)
+		}
+	}
+
+	columns := []struct {
+		table, column string
+		exists        bool
+	}{
+		{"foo bar", "id", true},
+		{"foo bar", "ID", true},
+		{"foo bar", `my "col"`, true},
+		{"foo bar", "missing", false},
+		{"missing", "id", false},
+		{"sqlite_sequence", "name", false},
+	}
+	for _, v := range columns {
+		exists, err := d.ColumnExists(v.table, v.column)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != v.exists {
+			t.Errorf("ColumnExists(%q, %q): expected %v, got %v", v.table, v.column, v.exists, exists)
+		}
+	}
+}
+
+func TestDropOrder(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	if _, err := d.db.Exec(`
+		CREATE TABLE a (id integer primary key, parent_id integer REFERENCES a (id));
+		CREATE TABLE b (id integer primary key, c_id integer REFERENCES c (id), a_id integer REFERENCES A (id));
+		CREATE TABLE c (id integer primary key);
+		CREATE TABLE d (id integer primary key, b_id integer REFERENCES b (id));
+		CREATE TABLE x (id integer primary key, y_id integer REFERENCES y (id));
+		CREATE TABLE y (id integer primary key, x_id integer REFERENCES x (id));`); err != nil {
+		t.Fatal(err)
+	}
+
+	order, err := d.dropOrder([]string{"a", "b", "c", "d", "x", "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"d", "b", "c", "a", "x", "y"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Fatalf("expected %v, got %v", expected, order)
+	}
+}
+
+func TestDropTable(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+	if err := d.Run(bytes.NewReader([]byte(`CREATE TABLE "foo bar" (id int); CREATE TABLE seq (id integer primary key autoincrement); INSERT INTO seq DEFAULT VALUES;`))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.DropTable("foo bar"); err != nil {
+		t.Fatal(err)
+	}
+	if tableExists(t, d, "main", "foo bar") {
+		t.Fatal("expected table foo bar to be dropped")
+	}
+
+	if err := d.DropTable("missing"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"", "schema_migrations", "SCHEMA_MIGRATIONS", "sqlite_sequence"} {
+		if err := d.DropTable(name); err == nil {
+			t.Errorf("expected dropping %q to fail", name)
+		}
+	}
+	for _, name := range []string{"schema_migrations", "sqlite_sequence"} {
+		if !tableExists(t, d, "main", name) {
+			t.Errorf("expected table %v to exist", name)
+		}
+	}
+}
+
+func TestForEachTable(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+	if err := d.Run(bytes.NewReader([]byte(`CREATE TABLE foo (id int); CREATE TABLE bar (id integer primary key autoincrement); CREATE TABLE "baz qux" (id int);`))); err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0)
+	err := d.ForEachTable(func(name string) error {
+		// the callback can query the database
+		exists, err := d.TableExists(name)
+		if err != nil || !exists {
+			return fmt.Errorf("expected table %v to exist, got %v", name, err)
+		}
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"bar", "baz qux", "foo"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected %q, got %q", expected, names)
+	}
+
+	stop := fmt.Errorf("stop")
+	calls := 0
+	err = d.ForEachTable(func(name string) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Fatalf("expected to stop after the first table, got %v after %v calls", err, calls)
+	}
+}
+
+func TestDumpSchema(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+	if err := d.Run(bytes.NewReader([]byte(`CREATE TABLE foo (id integer primary key autoincrement, name text);
+		CREATE TABLE bar (id int);
+		CREATE VIEW foo_names AS SELECT name FROM foo;
+		CREATE VIEW bar_ids AS SELECT id FROM bar;
+		CREATE INDEX foo_name_idx ON foo (name);
+		CREATE TRIGGER foo_insert AFTER INSERT ON foo BEGIN INSERT INTO bar VALUES (new.id); END;
+		INSERT INTO foo (name) VALUES ('a');`))); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetVersion(1, false); err != nil {
+		t.Fatal(err)
+	}
+
+	var schema bytes.Buffer
+	if err := d.DumpSchema(&schema); err != nil {
+		t.Fatal(err)
+	}
+	expected := `CREATE TABLE bar (id int);
+CREATE TABLE foo (id integer primary key autoincrement, name text);
+CREATE INDEX foo_name_idx ON foo (name);
+CREATE VIEW foo_names AS SELECT name FROM foo;
+CREATE VIEW bar_ids AS SELECT id FROM bar;
+CREATE TRIGGER foo_insert AFTER INSERT ON foo BEGIN INSERT INTO bar VALUES (new.id); END;
+`
+	if schema.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, schema.String())
+	}
+
+	// the dump recreates the schema in a fresh database
+	other, cleanupOther := tempDir(t)
+	defer cleanupOther()
+	e := open(t, other, "")
+	defer e.Close()
+	if err := e.Run(bytes.NewReader(schema.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	var again bytes.Buffer
+	if err := e.DumpSchema(&again); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, again.String())
+	}
+	var count int
+	if err := e.db.QueryRow(`SELECT COUNT(1) FROM foo`).Scan(&count); err != nil || count != 0 {
+		t.Fatalf("expected no rows to be dumped, got %v (%v)", count, err)
+	}
+}
+
+func TestIncompatibleVersionTable(t *testing.T) {
+	tt := []struct {
+		table        string
+		query        string
+		incompatible bool
+	}{
+		{"version bigint not null primary key, dirty boolean not null", "", false},
+		{"version bigint not null primary key, dirty boolean not null, comment text, attempts int not null default 0", "", false},
+		{"version integer primary key, dirty boolean not null", "", false},
+		{"version bigint not null unique, dirty boolean not null", "", false},
+		{"version bigint not null, dirty boolean not null, applied_at datetime not null", "?x-history=on", false},
+		{"id text not null primary key, applied boolean", "", true},
+		{"version bigint not null primary key", "", true},
+		{"version bigint not null primary key, dirty boolean not null, owner text not null", "", true},
+		{"version bigint not null, dirty boolean not null, applied_at datetime not null", "", true},
+		{"version bigint not null primary key, dirty boolean not null", "?x-history=on", true},
+	}
+
+	for i, v := range tt {
+		dir, cleanup := tempDir(t)
+		path := filepath.Join(dir, "sqlite.db")
+		db, err := sql.Open("sqlite3", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`CREATE TABLE schema_migrations (` + v.table + `)`); err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+
+		d, err := (&Sqlite{}).Open("sqlite3://" + path + v.query)
+		if v.incompatible {
+			if e, ok := err.(ErrIncompatibleVersionTable); !ok || e.Table != "schema_migrations" {
+				t.Errorf("%v: expected ErrIncompatibleVersionTable, got %v", i, err)
+			}
+		} else if err != nil {
+			t.Errorf("%v: %v", i, err)
+		} else {
+			if err := d.SetVersion(2, false); err != nil {
+				t.Errorf("%v: %v", i, err)
+			}
+			if version, _, err := d.Version(); err != nil || version != 2 {
+				t.Errorf("%v: expected version 2, got %v (%v)", i, version, err)
+			}
+			d.Close()
+		}
+		cleanup()
+	}
+}
+
+func TestCheckForeignKeys(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	if err := d.Run(bytes.NewReader([]byte(`CREATE TABLE authors (id integer primary key);
+CREATE TABLE books (id integer primary key, author_id int REFERENCES authors (id));
+INSERT INTO authors VALUES (1);
+INSERT INTO books VALUES (10, 1);`))); err != nil {
+		t.Fatal(err)
+	}
+	violations, err := d.CheckForeignKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("expected no violations, got %+v", violations)
+	}
+
+	// foreign keys are off by default, so the orphan isn't caught
+	if err := d.Run(bytes.NewReader([]byte("INSERT INTO books VALUES (11, 2);"))); err != nil {
+		t.Fatal(err)
+	}
+	if violations, err = d.CheckForeignKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 1 {
+		t.Fatalf("expected 1 violation, got %+v", violations)
+	}
+	v := violations[0]
+	if v.Table != "books" || v.RowID == nil || *v.RowID != 11 || v.Parent != "authors" || v.FKID != 0 {
+		t.Fatalf("expected the orphaned book 11, got %+v", v)
+	}
+}
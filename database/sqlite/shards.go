@@ -0,0 +1,87 @@
+package sqlite
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// ShardError is the failure of a migration on one of the databases
+// of RunAll.
+type ShardError struct {
+	DatabaseName string
+	Err          error
+}
+
+// ErrShards collects the failures of RunAll, in the order of the
+// databases passed to it.
+type ErrShards []ShardError
+
+func (e ErrShards) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, s := range e {
+		msgs = append(msgs, fmt.Sprintf("%v: %v", s.DatabaseName, s.Err))
+	}
+	return "migration failed: " + strings.Join(msgs, "; ")
+}
+
+// OpenAll opens the databases of urls like Open does, e.g. the shards
+// of a deployment with a database per tenant. If one of them can't be
+// opened, the ones opened before are closed again.
+func OpenAll(urls []string) ([]*Sqlite, error) {
+	drivers := make([]*Sqlite, 0, len(urls))
+	for i, url := range urls {
+		d, err := (&Sqlite{}).Open(url)
+		if err != nil {
+			for _, opened := range drivers {
+				opened.Close()
+			}
+			// the URL may contain a key, don't return it
+			return nil, fmt.Errorf("open database %v: %v", i, err)
+		}
+		drivers = append(drivers, d.(*Sqlite))
+	}
+	return drivers, nil
+}
+
+// RunAll runs migration on every driver, each locked on its own unless
+// it is locked already. A failure on one database doesn't keep the
+// migration from running on the others, the failures are returned as
+// ErrShards.
+func RunAll(drivers []*Sqlite, migration io.Reader) error {
+	migr, err := ioutil.ReadAll(migration)
+	if err != nil {
+		return err
+	}
+
+	failed := make(ErrShards, 0)
+	for _, d := range drivers {
+		err := d.runLocked(bytes.NewReader(migr))
+		d.handleError(&err)
+		if err != nil {
+			failed = append(failed, ShardError{DatabaseName: d.config.DatabaseName, Err: err})
+		}
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// runLocked runs migration, locking the database while it runs
+// unless it is locked already.
+func (s *Sqlite) runLocked(migration io.Reader) (err error) {
+	if !s.isLocked {
+		if err := s.lock(); err != nil {
+			return err
+		}
+		defer func() {
+			if uerr := s.unlock(); err == nil {
+				err = uerr
+			}
+		}()
+	}
+	return s.run(migration)
+}
@@ -0,0 +1,59 @@
+package sqlite
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunAll(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	urls := []string{
+		"sqlite3://" + filepath.Join(dir, "tenant1.db"),
+		"sqlite3://" + filepath.Join(dir, "tenant2.db"),
+	}
+	drivers, err := OpenAll(urls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range drivers {
+		defer d.Close()
+	}
+
+	if err := RunAll(drivers, bytes.NewReader([]byte(`CREATE TABLE foo (id int);`))); err != nil {
+		t.Fatal(err)
+	}
+	for i, d := range drivers {
+		if !tableExists(t, d, "main", "foo") {
+			t.Fatalf("expected table foo in database %v", i)
+		}
+	}
+
+	// the second shard fails, the first one is migrated anyway
+	if err := drivers[1].Run(bytes.NewReader([]byte(`CREATE TABLE bar (id int);`))); err != nil {
+		t.Fatal(err)
+	}
+	err = RunAll(drivers, bytes.NewReader([]byte(`CREATE TABLE bar (id int);`)))
+	e, ok := err.(ErrShards)
+	if !ok || len(e) != 1 || e[0].DatabaseName != filepath.Join(dir, "tenant2.db") {
+		t.Fatalf("expected the second database to fail, got %v", err)
+	}
+	if !strings.Contains(e[0].Err.Error(), "already exists") {
+		t.Fatalf("expected table bar to exist already, got %v", e[0].Err)
+	}
+	if !tableExists(t, drivers[0], "main", "bar") {
+		t.Fatalf("expected table bar in the first database")
+	}
+	for i, d := range drivers {
+		if d.isLocked {
+			t.Fatalf("expected database %v to be unlocked", i)
+		}
+	}
+
+	if _, err := OpenAll([]string{urls[0], "sqlite3://" + dir}); err == nil {
+		t.Fatalf("expected opening a directory to fail")
+	}
+}
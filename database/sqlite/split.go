@@ -0,0 +1,405 @@
+package sqlite
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Splitter splits a migration into the statements Run executes
+// one by one. See WithSplitter.
+type Splitter interface {
+	Split(migration string) ([]string, error)
+}
+
+// SmartSplitter splits migrations at semicolons, see splitStatements.
+// It is the default.
+type SmartSplitter struct{}
+
+func (SmartSplitter) Split(migration string) ([]string, error) {
+	return splitStatements(migration), nil
+}
+
+// MarkerSplitter splits migrations at lines consisting of Marker only,
+// see Config.StatementMarker.
+type MarkerSplitter struct {
+	Marker string
+}
+
+func (m MarkerSplitter) Split(migration string) ([]string, error) {
+	if len(m.Marker) == 0 {
+		return nil, fmt.Errorf("no statement marker")
+	}
+	return splitOnMarker(migration, m.Marker), nil
+}
+
+// NoSplitter doesn't split migrations, they are executed as a whole.
+// ATTACH, DETACH and migrate:no-tx statements are only recognized
+// at the start of a migration then.
+type NoSplitter struct{}
+
+func (NoSplitter) Split(migration string) ([]string, error) {
+	if stmt := strings.TrimSpace(migration); len(stmt) > 0 {
+		return []string{stmt}, nil
+	}
+	return []string{}, nil
+}
+
+// splitStatements splits a migration into its statements. Statements are
+// terminated by semicolons, unless the semicolon is part of a string literal,
+// a quoted identifier, a comment or the body of a CREATE TRIGGER statement.
+// Chunks containing nothing but whitespace are dropped.
+func splitStatements(migration string) []string {
+	stmts := make([]string, 0)
+	s := &scanner{src: migration}
+
+	start := 0
+	words := make([]string, 0, 4)
+	depth := 0 // BEGIN/CASE ... END nesting inside of trigger bodies
+
+	for !s.done() {
+		switch c := s.peek(); {
+		case c == '\'' || c == '"' || c == '`':
+			s.skipQuoted(c)
+
+		case c == '[':
+			s.skipQuoted(']')
+
+		case c == '-' && s.peekAt(1) == '-':
+			s.skipLineComment()
+
+		case c == '/' && s.peekAt(1) == '*':
+			s.skipBlockComment()
+
+		case isIdentStart(c):
+			word := strings.ToUpper(s.readWord())
+			if len(words) < cap(words) {
+				words = append(words, word)
+			}
+			if isCreateTrigger(words) {
+				switch word {
+				case "BEGIN", "CASE":
+					depth++
+				case "END":
+					if depth > 0 {
+						depth--
+					}
+				}
+			}
+
+		case c == ';' && depth == 0:
+			s.pos++
+			if stmt := strings.TrimSpace(migration[start:s.pos]); len(stmt) > 0 {
+				stmts = append(stmts, stmt)
+			}
+			start = s.pos
+			words = words[:0]
+
+		default:
+			s.pos++
+		}
+	}
+
+	if stmt := strings.TrimSpace(migration[start:]); len(stmt) > 0 {
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
+
+// splitOnMarker splits a migration into statements at lines consisting
+// of marker only, so statements don't have to be terminated by semicolons.
+// Chunks containing nothing but whitespace are dropped.
+func splitOnMarker(migration, marker string) []string {
+	stmts := make([]string, 0)
+	lines := strings.SplitAfter(migration, "\n")
+
+	start := 0
+	offset := 0
+	for _, line := range lines {
+		if strings.TrimSpace(line) == marker {
+			if stmt := strings.TrimSpace(migration[start:offset]); len(stmt) > 0 {
+				stmts = append(stmts, stmt)
+			}
+			start = offset + len(line)
+		}
+		offset += len(line)
+	}
+
+	if stmt := strings.TrimSpace(migration[start:]); len(stmt) > 0 {
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
+
+// statementKeyword returns the first keyword of a statement in upper case,
+// skipping any leading whitespace and comments.
+func statementKeyword(stmt string) string {
+	words := leadingWords(stmt, 1)
+	if len(words) == 0 {
+		return ""
+	}
+	return words[0].text
+}
+
+// noTxDirective marks a statement which has to run outside of a transaction.
+const noTxDirective = "-- migrate:no-tx"
+
+// hasNoTxDirective reports if one of the comments leading stmt is a line
+// consisting of the migrate:no-tx directive only.
+func hasNoTxDirective(stmt string) bool {
+	s := &scanner{src: stmt}
+	for !s.done() {
+		switch c := s.peek(); {
+		case unicode.IsSpace(rune(c)):
+			s.pos++
+		case c == '-' && s.peekAt(1) == '-':
+			start := s.pos
+			s.skipLineComment()
+			if strings.TrimSpace(stmt[start:s.pos]) == noTxDirective {
+				return true
+			}
+		case c == '/' && s.peekAt(1) == '*':
+			s.skipBlockComment()
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// word is a keyword or unquoted identifier of a statement.
+type word struct {
+	// text is the word in upper case
+	text string
+
+	// end is the offset right after the word
+	end int
+}
+
+// leadingWords returns up to n words a statement starts with,
+// skipping whitespace and comments. It stops at the first token
+// that isn't a word.
+func leadingWords(stmt string, n int) []word {
+	words := make([]word, 0, n)
+	s := &scanner{src: stmt}
+	for !s.done() && len(words) < n {
+		switch c := s.peek(); {
+		case unicode.IsSpace(rune(c)):
+			s.pos++
+		case c == '-' && s.peekAt(1) == '-':
+			s.skipLineComment()
+		case c == '/' && s.peekAt(1) == '*':
+			s.skipBlockComment()
+		case isIdentStart(c):
+			words = append(words, word{text: strings.ToUpper(s.readWord()), end: s.pos})
+		default:
+			return words
+		}
+	}
+	return words
+}
+
+// rewriteIfExists adds IF EXISTS to DROP TABLE, INDEX, VIEW and TRIGGER
+// statements and IF NOT EXISTS to the matching CREATE statements, so
+// running them twice doesn't fail. Statements that already have the clause
+// or don't support it are returned unchanged.
+func rewriteIfExists(stmt string) string {
+	words := leadingWords(stmt, 5)
+	if len(words) < 2 {
+		return stmt
+	}
+
+	// i is the index of the object keyword
+	i := 1
+	clause := " IF EXISTS"
+	switch words[0].text {
+	case "DROP":
+
+	case "CREATE":
+		clause = " IF NOT EXISTS"
+		switch words[i].text {
+		case "TEMP", "TEMPORARY", "UNIQUE", "VIRTUAL":
+			i++
+		}
+		if i >= len(words) {
+			return stmt
+		}
+		if words[1].text == "UNIQUE" && words[i].text != "INDEX" {
+			return stmt
+		}
+		if words[1].text == "VIRTUAL" && words[i].text != "TABLE" {
+			return stmt
+		}
+
+	default:
+		return stmt
+	}
+
+	switch words[i].text {
+	case "TABLE", "INDEX", "VIEW", "TRIGGER":
+	default:
+		return stmt
+	}
+
+	// the clause comes right after the object keyword
+	if i+1 < len(words) && words[i+1].text == "IF" {
+		return stmt
+	}
+	return stmt[:words[i].end] + clause + stmt[words[i].end:]
+}
+
+// tokenize returns the tokens of stmt: words in upper case and any
+// other character as is, except for "::". String literals and quoted
+// identifiers are returned as their opening quote, comments are skipped.
+func tokenize(stmt string) []string {
+	tokens := make([]string, 0)
+	s := &scanner{src: stmt}
+	for !s.done() {
+		switch c := s.peek(); {
+		case c == '\'' || c == '"':
+			s.skipQuoted(c)
+			tokens = append(tokens, string(c))
+
+		case c == '[':
+			s.skipQuoted(']')
+			tokens = append(tokens, "[")
+
+		case c == '-' && s.peekAt(1) == '-':
+			s.skipLineComment()
+
+		case c == '/' && s.peekAt(1) == '*':
+			s.skipBlockComment()
+
+		case c == ':' && s.peekAt(1) == ':':
+			s.pos += 2
+			tokens = append(tokens, "::")
+
+		case isIdentStart(c):
+			tokens = append(tokens, strings.ToUpper(s.readWord()))
+
+		case unicode.IsSpace(rune(c)):
+			s.pos++
+
+		default:
+			s.pos++
+			tokens = append(tokens, string(c))
+		}
+	}
+	return tokens
+}
+
+// normalizeSQL returns migration with comments removed and its tokens
+// separated by single spaces, so reformatting a migration doesn't change
+// it. Words keep their case, string literals and quoted identifiers are
+// kept as they are.
+func normalizeSQL(migration string) string {
+	tokens := make([]string, 0)
+	s := &scanner{src: migration}
+	for !s.done() {
+		start := s.pos
+		switch c := s.peek(); {
+		case c == '\'' || c == '"' || c == '`':
+			s.skipQuoted(c)
+			tokens = append(tokens, migration[start:s.pos])
+
+		case c == '[':
+			s.skipQuoted(']')
+			tokens = append(tokens, migration[start:s.pos])
+
+		case c == '-' && s.peekAt(1) == '-':
+			s.skipLineComment()
+
+		case c == '/' && s.peekAt(1) == '*':
+			s.skipBlockComment()
+
+		case isIdentChar(c):
+			tokens = append(tokens, s.readWord())
+
+		case unicode.IsSpace(rune(c)):
+			s.pos++
+
+		default:
+			s.pos++
+			tokens = append(tokens, string(c))
+		}
+	}
+	return strings.Join(tokens, " ")
+}
+
+// isCreateTrigger reports if the leading words of a statement
+// start a CREATE [TEMP|TEMPORARY] TRIGGER statement.
+func isCreateTrigger(words []string) bool {
+	if len(words) < 2 || words[0] != "CREATE" {
+		return false
+	}
+	if words[1] == "TRIGGER" {
+		return true
+	}
+	return len(words) > 2 && (words[1] == "TEMP" || words[1] == "TEMPORARY") && words[2] == "TRIGGER"
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 0x80 || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c == '$' || (c >= '0' && c <= '9')
+}
+
+// scanner is a minimal byte scanner that knows about the
+// quoting and comment rules of SQLite.
+type scanner struct {
+	src string
+	pos int
+}
+
+func (s *scanner) done() bool {
+	return s.pos >= len(s.src)
+}
+
+func (s *scanner) peek() byte {
+	return s.src[s.pos]
+}
+
+func (s *scanner) peekAt(n int) byte {
+	if s.pos+n >= len(s.src) {
+		return 0
+	}
+	return s.src[s.pos+n]
+}
+
+func (s *scanner) readWord() string {
+	start := s.pos
+	for !s.done() && isIdentChar(s.peek()) {
+		s.pos++
+	}
+	return s.src[start:s.pos]
+}
+
+// skipQuoted skips a quoted string. Doubled quotes used for escaping are handled
+// as two consecutive strings.
+func (s *scanner) skipQuoted(close byte) {
+	s.pos++ // opening quote
+	for !s.done() && s.peek() != close {
+		s.pos++
+	}
+	if !s.done() {
+		s.pos++ // closing quote
+	}
+}
+
+func (s *scanner) skipLineComment() {
+	for !s.done() && s.peek() != '\n' {
+		s.pos++
+	}
+}
+
+func (s *scanner) skipBlockComment() {
+	s.pos += 2
+	for !s.done() && !(s.peek() == '*' && s.peekAt(1) == '/') {
+		s.pos++
+	}
+	if !s.done() {
+		s.pos += 2
+	}
+}
@@ -0,0 +1,132 @@
+package sqlite
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tt := []struct {
+		migration string
+		expected  []string
+	}{
+		{"", []string{}},
+		{" \n\t ", []string{}},
+		{"SELECT 1", []string{"SELECT 1"}},
+		{"SELECT 1;", []string{"SELECT 1;"}},
+		{"SELECT 1; SELECT 2;", []string{"SELECT 1;", "SELECT 2;"}},
+		{"SELECT 1;;\n;SELECT 2", []string{"SELECT 1;", ";", ";", "SELECT 2"}},
+		{"SELECT 'a;b'; SELECT 'it''s;'", []string{"SELECT 'a;b';", "SELECT 'it''s;'"}},
+		{`SELECT "a;b", [c;d], ` + "`e;f`;", []string{`SELECT "a;b", [c;d], ` + "`e;f`;"}},
+		{"SELECT 1 -- one; two\n; SELECT 2", []string{"SELECT 1 -- one; two\n;", "SELECT 2"}},
+		{"SELECT /* one; two */ 1; SELECT 2", []string{"SELECT /* one; two */ 1;", "SELECT 2"}},
+		{"BEGIN; SELECT 1; END;", []string{"BEGIN;", "SELECT 1;", "END;"}},
+		{
+			"CREATE TRIGGER t AFTER INSERT ON a BEGIN INSERT INTO b VALUES (1); UPDATE b SET x = CASE WHEN x > 1 THEN 0 END; END; SELECT 1;",
+			[]string{"CREATE TRIGGER t AFTER INSERT ON a BEGIN INSERT INTO b VALUES (1); UPDATE b SET x = CASE WHEN x > 1 THEN 0 END; END;", "SELECT 1;"},
+		},
+		{
+			"create temp trigger t before delete on a begin select 1; end; select 2",
+			[]string{"create temp trigger t before delete on a begin select 1; end;", "select 2"},
+		},
+	}
+
+	for i, v := range tt {
+		stmts := splitStatements(v.migration)
+		if !reflect.DeepEqual(stmts, v.expected) {
+			t.Errorf("%v: expected %q, got %q", i, v.expected, stmts)
+		}
+	}
+}
+
+func TestStatementKeyword(t *testing.T) {
+	tt := []struct {
+		stmt     string
+		expected string
+	}{
+		{"", ""},
+		{"attach 'foo.db' as foo", "ATTACH"},
+		{"  -- comment\n /* another */ DETACH foo", "DETACH"},
+		{"(SELECT 1)", ""},
+	}
+
+	for i, v := range tt {
+		if keyword := statementKeyword(v.stmt); keyword != v.expected {
+			t.Errorf("%v: expected %q, got %q", i, v.expected, keyword)
+		}
+	}
+}
+
+func TestRewriteIfExists(t *testing.T) {
+	tt := []struct {
+		stmt     string
+		expected string
+	}{
+		{"DROP TABLE foo", "DROP TABLE IF EXISTS foo"},
+		{"drop index foo_idx;", "drop index IF EXISTS foo_idx;"},
+		{"DROP VIEW \"foo bar\"", "DROP VIEW IF EXISTS \"foo bar\""},
+		{"DROP TRIGGER IF EXISTS foo", "DROP TRIGGER IF EXISTS foo"},
+		{"-- comment\nCREATE TABLE foo (id int)", "-- comment\nCREATE TABLE IF NOT EXISTS foo (id int)"},
+		{"CREATE TEMP TABLE foo (id int)", "CREATE TEMP TABLE IF NOT EXISTS foo (id int)"},
+		{"CREATE UNIQUE INDEX foo_idx ON foo (id)", "CREATE UNIQUE INDEX IF NOT EXISTS foo_idx ON foo (id)"},
+		{"CREATE VIRTUAL TABLE foo USING fts5(body)", "CREATE VIRTUAL TABLE IF NOT EXISTS foo USING fts5(body)"},
+		{"CREATE TRIGGER foo AFTER INSERT ON bar BEGIN SELECT 1; END", "CREATE TRIGGER IF NOT EXISTS foo AFTER INSERT ON bar BEGIN SELECT 1; END"},
+		{"CREATE TABLE IF NOT EXISTS foo (id int)", "CREATE TABLE IF NOT EXISTS foo (id int)"},
+		{"ALTER TABLE foo DROP COLUMN bar", "ALTER TABLE foo DROP COLUMN bar"},
+		{"DROP COLUMN foo", "DROP COLUMN foo"},
+		{"INSERT INTO foo VALUES (1)", "INSERT INTO foo VALUES (1)"},
+	}
+
+	for i, v := range tt {
+		if stmt := rewriteIfExists(v.stmt); stmt != v.expected {
+			t.Errorf("%v: expected %q, got %q", i, v.expected, stmt)
+		}
+	}
+}
+
+func TestSplitOnMarker(t *testing.T) {
+	marker := "-- +migrate StatementEnd"
+	tt := []struct {
+		migration string
+		expected  []string
+	}{
+		{"", []string{}},
+		{"SELECT 1; SELECT 2;", []string{"SELECT 1; SELECT 2;"}},
+		{"SELECT 1;\n-- +migrate StatementEnd\nSELECT 2;\n", []string{"SELECT 1;", "SELECT 2;"}},
+		{"SELECT 1;\r\n  -- +migrate StatementEnd  \r\nSELECT 2;\r\n" + marker, []string{"SELECT 1;", "SELECT 2;"}},
+		{marker + "\n\n" + marker + "\n", []string{}},
+		{"SELECT 1 -- +migrate StatementEnd\nSELECT 2", []string{"SELECT 1 -- +migrate StatementEnd\nSELECT 2"}},
+		{
+			"CREATE TRIGGER t AFTER INSERT ON a BEGIN\n  INSERT INTO b VALUES (1);\nEND;\n" + marker + "\nSELECT 1;",
+			[]string{"CREATE TRIGGER t AFTER INSERT ON a BEGIN\n  INSERT INTO b VALUES (1);\nEND;", "SELECT 1;"},
+		},
+	}
+
+	for i, v := range tt {
+		stmts := splitOnMarker(v.migration, marker)
+		if !reflect.DeepEqual(stmts, v.expected) {
+			t.Errorf("%v: expected %q, got %q", i, v.expected, stmts)
+		}
+	}
+}
+
+func TestHasNoTxDirective(t *testing.T) {
+	tt := []struct {
+		stmt     string
+		expected bool
+	}{
+		{"VACUUM", false},
+		{"-- migrate:no-tx\nVACUUM", true},
+		{"\n  -- migrate:no-tx  \r\n/* reclaim space */ VACUUM;", true},
+		{"-- reclaim space\n-- migrate:no-tx\nVACUUM", true},
+		{"-- migrate:no-tx please\nVACUUM", false},
+		{"/* -- migrate:no-tx */ VACUUM", false},
+		{"VACUUM -- migrate:no-tx", false},
+	}
+
+	for i, v := range tt {
+		if noTx := hasNoTxDirective(v.stmt); noTx != v.expected {
+			t.Errorf("%v: expected %v, got %v", i, v.expected, noTx)
+		}
+	}
+}
@@ -0,0 +1,1998 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	nurl "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mattes/migrate"
+	"github.com/mattes/migrate/database"
+	"github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	database.Register("sqlite3", &Sqlite{})
+}
+
+var DefaultMigrationsTable = "schema_migrations"
+
+// DefaultSavepointPrefix is the prefix of savepoint names,
+// unless set by WithSavepointPrefix.
+var DefaultSavepointPrefix = "txn"
+
+// DefaultCommitRetries is how often Unlock retries a COMMIT failing
+// because the database is busy, unless set by Config.CommitRetries.
+var DefaultCommitRetries int64 = 3
+
+// waitForUnlockInterval is how often WaitForUnlock tries to lock.
+const waitForUnlockInterval = 50 * time.Millisecond
+
+// commitRetryInterval is how long Unlock waits before retrying a COMMIT.
+const commitRetryInterval = 50 * time.Millisecond
+
+// savepointDepthWarning is the savepoint depth above which
+// transactionally logs a warning about runaway nesting.
+const savepointDepthWarning = 8
+
+var (
+	ErrNilConfig     = fmt.Errorf("no config")
+	ErrDatabaseDirty = fmt.Errorf("database is dirty")
+	ErrNoHistory     = fmt.Errorf("no history")
+
+	ErrInvalidEncoding   = fmt.Errorf("migration is not valid UTF-8")
+	ErrAlreadyVersioned  = fmt.Errorf("database already has a version")
+	ErrInitTimeout       = fmt.Errorf("timeout: can't initialize migrations table")
+	ErrReadOnly          = fmt.Errorf("driver is read-only")
+	ErrNoTxInTx          = fmt.Errorf("can't run migrate:no-tx statement inside of a transaction")
+	ErrPathIsDirectory   = fmt.Errorf("database path is a directory")
+	ErrDowngradeBlocked  = fmt.Errorf("refusing to lower the version in safe mode")
+	ErrNoVersionTable    = fmt.Errorf("no migrations table")
+	ErrNoSQLCipher       = fmt.Errorf("encryption key requires go-sqlite3 built with SQLCipher")
+	ErrMigrationTooLarge = fmt.Errorf("migration exceeds the maximum file size")
+	ErrSavepointTooDeep  = fmt.Errorf("savepoints nested too deep")
+	ErrVersionedStream   = fmt.Errorf("version directives can't be applied to a migration whose version is set by SetCurrentVersion")
+)
+
+// utf8BOM is the byte order mark some editors put in front of UTF-8 files.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+type Config struct {
+	MigrationsTable string
+	DatabaseName    string
+
+	// History keeps a row for every applied version in the migrations
+	// table, instead of the current version only.
+	History bool
+
+	// SecureDelete sets PRAGMA secure_delete (on, off or fast).
+	// SQLite's default is kept if empty.
+	SecureDelete string
+
+	// TempDir is the directory SQLite writes temporary files
+	// of sorts and index builds too large for memory, if /tmp is small.
+	// It must be a writable directory. It sets the deprecated PRAGMA
+	// temp_store_directory, which applies to all connections of the
+	// process. SQLite picks a directory if empty.
+	TempDir string
+
+	// PageSize sets PRAGMA page_size in bytes for new databases, a power
+	// of two between 512 and 65536. For existing databases with another
+	// page size a warning is logged. SQLite's default is kept if nil.
+	PageSize *int64
+
+	// AutoVacuum sets PRAGMA auto_vacuum (NONE, FULL or INCREMENTAL)
+	// for new databases. SQLite's default is kept if empty.
+	AutoVacuum string
+
+	// JournalSizeLimit sets PRAGMA journal_size_limit in bytes,
+	// -1 means no limit. SQLite's default is kept if nil.
+	JournalSizeLimit *int64
+
+	// Threads sets PRAGMA threads, the number of helper threads SQLite may
+	// use for sorting, as in CREATE INDEX on large tables. Other statements
+	// don't benefit. SQLite's default (0) is kept if nil.
+	Threads *int64
+
+	// WALAutocheckpoint sets PRAGMA wal_autocheckpoint, the number of
+	// WAL pages after which SQLite checkpoints automatically. 0 disables
+	// automatic checkpoints. SQLite's default (1000) is kept if nil.
+	WALAutocheckpoint *int64
+
+	// CommitRetries is how often Unlock retries a COMMIT that fails
+	// because other connections keep the database busy, on top of the
+	// busy timeout. DefaultCommitRetries applies if nil.
+	CommitRetries *int64
+
+	// RecursiveTriggers sets PRAGMA recursive_triggers, so triggers fire
+	// for changes made by triggers. SQLite's default (off) is kept if nil.
+	RecursiveTriggers *bool
+
+	// CellSizeCheck sets PRAGMA cell_size_check, so corrupt pages are
+	// detected when they are read, at a small cost of reading speed.
+	// SQLite's default (off) is kept if nil.
+	CellSizeCheck *bool
+
+	// IgnoreCheckConstraints sets PRAGMA ignore_check_constraints while
+	// the database is locked, for backfills. Rows violating CHECK
+	// constraints are written then and remain in the database, they fail
+	// later updates or an integrity check. Use with care.
+	IgnoreCheckConstraints bool
+
+	// IntegrityCheck runs PRAGMA quick_check (quick) or the slower, complete
+	// PRAGMA integrity_check (full) in Unlock, after committing. Unlock returns
+	// ErrIntegrityCheck if it doesn't return ok. No check runs if empty.
+	IntegrityCheck string
+
+	// QueryOnly sets PRAGMA query_only while the statements of a migration
+	// run, so migrations asserting the state of the database fail if they
+	// write by accident. The version is still written by SetVersion.
+	QueryOnly bool
+
+	// RewriteIfExists adds IF EXISTS and IF NOT EXISTS clauses to
+	// DROP and CREATE statements, so migrations can be re-run.
+	RewriteIfExists bool
+
+	// ExpandEnv replaces $NAME and ${NAME} outside of string literals,
+	// quoted identifiers and comments with the environment variable NAME
+	// before running a migration. Undefined variables fail the migration
+	// with ErrUndefinedVariable, unless RelaxedEnv is set, which expands
+	// them to the empty string.
+	ExpandEnv  bool
+	RelaxedEnv bool
+
+	// VersionDirectives makes Run treat migrations as streams of several
+	// migrations, each starting with a "-- +version N" line. Run applies
+	// them with ApplyInOrder, so the version advances after each block.
+	// Migrations without directives run as usual. Streams can't be run by
+	// migrate itself, which sets the version of the migration it runs, see
+	// ErrVersionedStream.
+	VersionDirectives bool
+
+	// NoTx runs every statement of a migration outside of a transaction,
+	// as if each had a "-- migrate:no-tx" line, for migrations made of
+	// statements like VACUUM. Statements that fail leave the ones before
+	// them applied.
+	NoTx bool
+
+	// Lint rejects migrations using PostgreSQL or MySQL specific SQL
+	// before running them. See ErrForeignSQL.
+	Lint bool
+
+	// SafeMode rejects migrations with DROP TABLE, DROP DATABASE, TRUNCATE
+	// or DELETE statements without WHERE clause before running them,
+	// unless AllowDestructive is set. See ErrDestructive. It also rejects
+	// lowering the version, unless AllowDowngrade is set.
+	SafeMode         bool
+	AllowDestructive bool
+	AllowDowngrade   bool
+
+	// StatementMarker splits migrations at lines consisting of the marker,
+	// e.g. "-- +migrate StatementEnd", instead of at semicolons.
+	StatementMarker string
+
+	// InitTimeout limits how long WithInstance waits for locks of other
+	// connections while creating the migrations table. If zero, the
+	// connection's busy timeout applies.
+	InitTimeout time.Duration
+
+	// Explain logs the query plan of SELECT, INSERT ... SELECT, UPDATE
+	// and DELETE statements before running them. Requires Log.
+	Explain bool
+
+	// ReadOnly makes Lock, Run, SetVersion and Drop return ErrReadOnly,
+	// and the migrations table isn't created if it doesn't exist.
+	ReadOnly bool
+
+	// VersionWriter receives a "version=<N>" line for every version set
+	// by SetVersion that isn't dirty, for shell scripts to read.
+	VersionWriter io.Writer
+
+	// Checksum stores the SHA-256 of the last migration run along with
+	// the version, ignoring comments and whitespace. A migration identical
+	// to the last one run is skipped, so a migration file copied to a
+	// new version by accident doesn't run twice.
+	Checksum bool
+
+	// DropExcept are tables Drop keeps, such as reference data of tests.
+	DropExcept []string
+
+	// MaxFileSize is the maximum size of a migration in bytes, larger
+	// migrations fail with ErrMigrationTooLarge before anything is run.
+	// Unlimited if zero.
+	MaxFileSize int64
+
+	// CheckpointOnClose copies all of the WAL into the database and
+	// truncates the WAL when the driver is closed in WAL mode, so the
+	// database file is complete even if other connections keep the WAL.
+	CheckpointOnClose bool
+
+	// PreparedCacheSize is the number of statements ExecPrepared keeps
+	// prepared. DefaultPreparedCacheSize applies if zero.
+	PreparedCacheSize int
+
+	// InitSQL is the path of a file with statements to run once connected,
+	// for example to create temporary views. It is split into statements
+	// like migrations are. Drivers opened by Open or OpenWithOptions run
+	// it on every new connection, right after connecting, including the
+	// read-only one of Version, so it must not write to the database.
+	// WithInstance can't see new connections of instance and runs it on
+	// the current one only, after the pragmas are set.
+	InitSQL string
+
+	// BeforeDrop is called by Drop before anything is dropped, if set.
+	// Drop is aborted with the error it returns, so it can ask
+	// for confirmation or refuse dropping production databases.
+	BeforeDrop func() error
+
+	// Log receives debug output if Log.Verbose() returns true. Optional.
+	Log migrate.Logger
+
+	// LogLevel enables the built-in logger writing to stderr, if Log
+	// isn't set. It is silent by default.
+	LogLevel LogLevel
+}
+
+type Sqlite struct {
+	db       *sql.DB
+	isLocked bool
+
+	// savepoints is the nesting depth of transactionally
+	savepoints int
+
+	// currentVersion is the version of the migration passed to Run,
+	// as set by SetCurrentVersion. NilVersion if unknown.
+	currentVersion int
+
+	// checksum of the last migration run, stored by the next SetVersion
+	checksum string
+
+	// savepointPrefix set by WithSavepointPrefix, empty for the default
+	savepointPrefix string
+
+	// maxSavepoints set by WithMaxSavepointDepth, 0 for unlimited
+	maxSavepoints int
+
+	// splitter set by WithSplitter, nil for the default
+	splitter Splitter
+
+	// errorHandler set by WithErrorHandler, nil for none
+	errorHandler func(error) error
+
+	// now returns the time written to applied_at, see WithTimeNow
+	now func() time.Time
+
+	// prepared statements of ExecPrepared, nil until first used
+	prepared *preparedCache
+
+	// timings of the migrations run, see Timings
+	timings []MigrationTiming
+
+	// rowsAffected collects the rows changed by each statement
+	// while RunWithResult runs, nil otherwise
+	rowsAffected []int64
+
+	// detach holds DETACH statements which have to wait until
+	// the lock's transaction is committed
+	detach []string
+
+	// checkConstraints is the value of PRAGMA ignore_check_constraints
+	// to restore on Unlock, empty if Lock didn't change it
+	checkConstraints string
+
+	// wal is true if the database is in WAL mode, see Lock and Version
+	wal bool
+
+	// reader is a read-only connection for Version, opened on first use
+	reader   *sql.DB
+	readerMu sync.Mutex
+
+	// readerDSN opens reader with the parameters of the driver's own
+	// connection, set by OpenWithOptions. The bare file is opened if empty.
+	readerDSN string
+
+	// connectHook is run on every connection OpenWithOptions opens,
+	// see withConnectHook
+	connectHook func(*sqlite3.SQLiteConn) error
+
+	// Open and WithInstance need to garantuee that config is never nil
+	config *Config
+}
+
+// WithInstance returns a driver for an existing database instance.
+// The locking model of this driver requires all statements to run
+// on the same connection, so the instance is limited to one open connection.
+// Options are applied after config.
+func WithInstance(instance *sql.DB, config *Config, opts ...Option) (database.Driver, error) {
+	if config == nil {
+		return nil, ErrNilConfig
+	}
+
+	instance.SetMaxOpenConns(1)
+
+	if err := instance.Ping(); err != nil {
+		return nil, err
+	}
+
+	// defaults and options change the driver's copy only,
+	// so config can be reused for other drivers
+	c := *config
+	config = &c
+
+	if len(config.MigrationsTable) == 0 {
+		config.MigrationsTable = DefaultMigrationsTable
+	}
+
+	if _, ok := integrityChecks[strings.ToLower(config.IntegrityCheck)]; !ok && len(config.IntegrityCheck) > 0 {
+		return nil, fmt.Errorf("invalid integrity check %q, expected quick or full", config.IntegrityCheck)
+	}
+
+	if config.Log == nil && config.LogLevel > LogSilent {
+		config.Log = newLevelLogger(config.LogLevel, logOutput)
+	}
+
+	sx := &Sqlite{
+		db:             instance,
+		config:         config,
+		currentVersion: database.NilVersion,
+	}
+
+	for _, opt := range opts {
+		if err := opt(sx); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := sx.applyPragmas(); err != nil {
+		return nil, err
+	}
+
+	if err := sx.runInitSQL(); err != nil {
+		return nil, err
+	}
+
+	journalMode, err := sx.pragma("journal_mode")
+	if err != nil {
+		return nil, err
+	}
+	sx.wal = journalMode == "wal"
+	sx.logVerbosePrintf("pragma journal_mode reads back %v\n", journalMode)
+
+	if err := sx.ensureVersionTableTimeout(); err != nil {
+		return nil, err
+	}
+
+	return sx, nil
+}
+
+func (s *Sqlite) Open(url string) (database.Driver, error) {
+	purl, err := nurl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts Options
+	q := purl.Query()
+
+	opts.MigrationsTable = q.Get("x-migrations-table")
+
+	if opts.History, err = parseBool(q.Get("x-history")); err != nil {
+		return nil, fmt.Errorf("x-history: %v", err)
+	}
+
+	if opts.Lint, err = parseBool(q.Get("x-lint")); err != nil {
+		return nil, fmt.Errorf("x-lint: %v", err)
+	}
+
+	if opts.SafeMode, err = parseBool(q.Get("x-safe-mode")); err != nil {
+		return nil, fmt.Errorf("x-safe-mode: %v", err)
+	}
+
+	if opts.AllowDestructive, err = parseBool(q.Get("x-allow-destructive")); err != nil {
+		return nil, fmt.Errorf("x-allow-destructive: %v", err)
+	}
+
+	if opts.AllowDowngrade, err = parseBool(q.Get("x-allow-downgrade")); err != nil {
+		return nil, fmt.Errorf("x-allow-downgrade: %v", err)
+	}
+
+	if opts.JournalSizeLimit, err = parseInt(q.Get("x-journal-size-limit"), -1); err != nil {
+		return nil, fmt.Errorf("x-journal-size-limit: %v", err)
+	}
+
+	if opts.Checksum, err = parseBool(q.Get("x-checksum")); err != nil {
+		return nil, fmt.Errorf("x-checksum: %v", err)
+	}
+
+	if opts.PageSize, err = parseInt(q.Get("x-page-size"), 512); err != nil {
+		return nil, fmt.Errorf("x-page-size: %v", err)
+	}
+
+	if opts.Threads, err = parseInt(q.Get("x-threads"), 0); err != nil {
+		return nil, fmt.Errorf("x-threads: %v", err)
+	}
+
+	if opts.LogLevel, err = parseLogLevel(q.Get("x-log-level")); err != nil {
+		return nil, fmt.Errorf("x-log-level: %v", err)
+	}
+
+	if opts.CommitRetries, err = parseInt(q.Get("x-commit-retries"), 0); err != nil {
+		return nil, fmt.Errorf("x-commit-retries: %v", err)
+	}
+
+	if opts.WALAutocheckpoint, err = parseInt(q.Get("x-wal-autocheckpoint"), 0); err != nil {
+		return nil, fmt.Errorf("x-wal-autocheckpoint: %v", err)
+	}
+
+	if v := q.Get("x-recursive-triggers"); len(v) > 0 {
+		recursiveTriggers, err := parseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("x-recursive-triggers: %v", err)
+		}
+		opts.RecursiveTriggers = &recursiveTriggers
+	}
+
+	if v := q.Get("x-cell-size-check"); len(v) > 0 {
+		cellSizeCheck, err := parseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("x-cell-size-check: %v", err)
+		}
+		opts.CellSizeCheck = &cellSizeCheck
+	}
+
+	if opts.IgnoreCheckConstraints, err = parseBool(q.Get("x-ignore-check-constraints")); err != nil {
+		return nil, fmt.Errorf("x-ignore-check-constraints: %v", err)
+	}
+
+	if opts.UTCTimes, err = parseBool(q.Get("x-utc-times")); err != nil {
+		return nil, fmt.Errorf("x-utc-times: %v", err)
+	}
+
+	if opts.QueryOnly, err = parseBool(q.Get("x-query-only")); err != nil {
+		return nil, fmt.Errorf("x-query-only: %v", err)
+	}
+
+	if opts.CheckpointOnClose, err = parseBool(q.Get("x-checkpoint-on-close")); err != nil {
+		return nil, fmt.Errorf("x-checkpoint-on-close: %v", err)
+	}
+
+	if opts.Explain, err = parseBool(q.Get("x-explain")); err != nil {
+		return nil, fmt.Errorf("x-explain: %v", err)
+	}
+
+	if opts.ReadOnly, err = parseBool(q.Get("x-read-only")); err != nil {
+		return nil, fmt.Errorf("x-read-only: %v", err)
+	}
+
+	if v := q.Get("x-init-timeout"); len(v) > 0 {
+		if opts.InitTimeout, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("x-init-timeout: %v", err)
+		}
+	}
+
+	switch v := q.Get("x-if-exists"); v {
+	case "", "off":
+	case "rewrite":
+		opts.RewriteIfExists = true
+	default:
+		return nil, fmt.Errorf("x-if-exists: invalid value %q, expected rewrite or off", v)
+	}
+
+	switch v := strings.ToLower(q.Get("x-expand-env")); v {
+	case "":
+	case "relaxed":
+		opts.ExpandEnv = true
+		opts.RelaxedEnv = true
+	default:
+		if opts.ExpandEnv, err = parseBool(v); err != nil {
+			return nil, fmt.Errorf("x-expand-env: invalid value %q, expected on, off or relaxed", v)
+		}
+	}
+
+	if opts.VersionDirectives, err = parseBool(q.Get("x-version-directives")); err != nil {
+		return nil, fmt.Errorf("x-version-directives: %v", err)
+	}
+
+	if opts.NoTx, err = parseBool(q.Get("x-no-tx")); err != nil {
+		return nil, fmt.Errorf("x-no-tx: %v", err)
+	}
+
+	echoVersion, err := parseBool(q.Get("x-echo-version"))
+	if err != nil {
+		return nil, fmt.Errorf("x-echo-version: %v", err)
+	}
+	if echoVersion {
+		opts.VersionWriter = os.Stdout
+	}
+
+	if v := q.Get("x-drop-except"); len(v) > 0 {
+		for _, table := range strings.Split(v, ",") {
+			table = strings.TrimSpace(table)
+			if !isIdentifierFragment(table) {
+				return nil, fmt.Errorf("x-drop-except: invalid table name %q", table)
+			}
+			opts.DropExcept = append(opts.DropExcept, table)
+		}
+	}
+
+	if v := q.Get("x-random-seed"); len(v) > 0 {
+		seed, err := parseInt(v, math.MinInt64)
+		if err != nil {
+			return nil, fmt.Errorf("x-random-seed: %v", err)
+		}
+		opts.RandomSeed = seed
+	}
+
+	// the key may be the password of the URL, as in sqlite3://:key@/path/db
+	opts.Key = q.Get("x-key")
+	if password, ok := purl.User.Password(); ok {
+		if len(opts.Key) > 0 {
+			return nil, fmt.Errorf("x-key conflicts with the key in the URL, use one of them")
+		}
+		opts.Key = password
+	}
+
+	if v := q.Get("x-max-file-size"); len(v) > 0 {
+		size, err := parseInt(v, 1)
+		if err != nil {
+			return nil, fmt.Errorf("x-max-file-size: %v", err)
+		}
+		opts.MaxFileSize = *size
+	}
+
+	opts.InitSQL = q.Get("x-init-sql")
+	opts.SecureDelete = q.Get("x-secure-delete")
+	opts.TempDir = q.Get("x-temp-dir")
+	opts.AutoVacuum = q.Get("x-auto-vacuum")
+	opts.IntegrityCheck = q.Get("x-integrity-check")
+	opts.StatementMarker = q.Get("x-statement-marker")
+
+	// the remaining parameters are passed on to go-sqlite3, the ones
+	// known to Options are validated along with them
+	opts.Params = migrate.FilterCustomQuery(purl).Query()
+
+	opts.JournalMode = opts.Params.Get("_journal_mode")
+	opts.Params.Del("_journal_mode")
+
+	if opts.EnforceJournalMode, err = parseBool(q.Get("x-enforce-journal-mode")); err != nil {
+		return nil, fmt.Errorf("x-enforce-journal-mode: %v", err)
+	}
+
+	if v := opts.Params.Get("_busy_timeout"); len(v) > 0 {
+		ms, err := parseInt(v, 0)
+		if err != nil {
+			return nil, fmt.Errorf("_busy_timeout: %v", err)
+		}
+		opts.BusyTimeout = time.Duration(*ms) * time.Millisecond
+	}
+	opts.Params.Del("_busy_timeout")
+
+	if v := opts.Params.Get("_foreign_keys"); len(v) > 0 {
+		foreignKeys, err := parseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("_foreign_keys: %v", err)
+		}
+		opts.ForeignKeys = &foreignKeys
+	}
+	opts.Params.Del("_foreign_keys")
+
+	if opts.Immutable, err = parseBool(opts.Params.Get("immutable")); err != nil {
+		return nil, fmt.Errorf("immutable: %v", err)
+	}
+	opts.Params.Del("immutable")
+
+	// use the decoded path, it may contain spaces or other special characters
+	return OpenWithOptions(purl.Host+purl.Path, opts)
+}
+
+func (s *Sqlite) Close() (err error) {
+	defer s.handleError(&err)
+	return s.close()
+}
+
+func (s *Sqlite) close() error {
+	if s.prepared != nil {
+		s.prepared.clear()
+	}
+
+	s.readerMu.Lock()
+	defer s.readerMu.Unlock()
+	if s.reader != nil {
+		s.reader.Close()
+		s.reader = nil
+	}
+
+	if s.wal && s.config.CheckpointOnClose {
+		query := `PRAGMA wal_checkpoint(TRUNCATE)`
+		if _, err := s.db.Exec(query); err != nil {
+			s.db.Close()
+			return &database.Error{OrigErr: err, Query: []byte(query)}
+		}
+	}
+	return s.db.Close()
+}
+
+// Lock starts an exclusive transaction which is held until Unlock.
+// The exclusive locking mode makes sure SQLite doesn't give up the
+// lock should a migration commit the transaction on its own.
+// https://www.sqlite.org/pragma.html#pragma_locking_mode
+//
+// In WAL mode the exclusive locking mode would keep other connections
+// from reading, so it isn't used: readers see the last committed
+// version while migrations run, other writers are kept out by the
+// transaction only.
+func (s *Sqlite) Lock() (err error) {
+	defer s.handleError(&err)
+	return s.lock()
+}
+
+func (s *Sqlite) lock() error {
+	if s.config.ReadOnly {
+		return ErrReadOnly
+	}
+	if s.isLocked {
+		return database.ErrLocked
+	}
+
+	if !s.wal {
+		query := `PRAGMA locking_mode = EXCLUSIVE`
+		if _, err := s.db.Exec(query); err != nil {
+			return &database.Error{OrigErr: err, Err: "try lock failed", Query: []byte(query)}
+		}
+
+		// make sure the locking mode was applied, SQLite ignores
+		// the pragma silently if it can't change it
+		mode, err := s.pragma("locking_mode")
+		if err != nil {
+			s.db.Exec(`PRAGMA locking_mode = NORMAL`)
+			return err
+		}
+		if mode != "exclusive" {
+			s.db.Exec(`PRAGMA locking_mode = NORMAL`)
+			return &database.Error{Err: fmt.Sprintf("try lock failed: locking mode is %v, expected exclusive", mode), Query: []byte(query)}
+		}
+	}
+
+	query := `BEGIN EXCLUSIVE`
+	if _, err := s.db.Exec(query); err != nil {
+		if !s.wal {
+			s.db.Exec(`PRAGMA locking_mode = NORMAL`)
+		}
+		if isBusy(err) {
+			return database.ErrLocked
+		}
+		return &database.Error{OrigErr: err, Err: "try lock failed", Query: []byte(query)}
+	}
+
+	s.isLocked = true
+
+	if s.config.IgnoreCheckConstraints {
+		current, err := s.pragma("ignore_check_constraints")
+		if err == nil {
+			err = s.setPragma("ignore_check_constraints", "ON")
+		}
+		if err != nil {
+			s.unlock()
+			return err
+		}
+		s.checkConstraints = current
+	}
+	return nil
+}
+
+func (s *Sqlite) Unlock() (err error) {
+	defer s.handleError(&err)
+	return s.unlock()
+}
+
+func (s *Sqlite) unlock() error {
+	if !s.isLocked {
+		return nil
+	}
+
+	if err := s.commit(); err != nil {
+		return err
+	}
+	s.isLocked = false
+
+	if len(s.checkConstraints) > 0 {
+		err := s.setPragma("ignore_check_constraints", s.checkConstraints)
+		s.checkConstraints = ""
+		if err != nil {
+			return err
+		}
+	}
+
+	if !s.wal {
+		// the lock is released with the next read after switching back
+		query := `PRAGMA locking_mode = NORMAL`
+		if _, err := s.db.Exec(query); err != nil {
+			return &database.Error{OrigErr: err, Query: []byte(query)}
+		}
+		query = `SELECT COUNT(1) FROM sqlite_master`
+		var count int
+		if err := s.db.QueryRow(query).Scan(&count); err != nil {
+			return &database.Error{OrigErr: err, Query: []byte(query)}
+		}
+	}
+
+	if err := s.runDetach(); err != nil {
+		return err
+	}
+	return s.checkIntegrity()
+}
+
+// ErrCommitBusy is returned by Unlock if COMMIT kept failing because
+// other connections keep the database busy. The transaction is still
+// open and the database locked, Unlock can be called again.
+type ErrCommitBusy struct {
+	Attempts int64
+	OrigErr  error
+}
+
+func (e ErrCommitBusy) Error() string {
+	return fmt.Sprintf("transaction commit failed after %v attempts, database is busy: %v", e.Attempts, e.OrigErr)
+}
+
+// commit commits the transaction of the lock, retrying while the
+// database is busy. SQLite keeps the transaction open then.
+// https://www.sqlite.org/lang_transaction.html
+func (s *Sqlite) commit() error {
+	retries := DefaultCommitRetries
+	if s.config.CommitRetries != nil {
+		retries = *s.config.CommitRetries
+	}
+
+	query := `COMMIT`
+	for attempt := int64(1); ; attempt++ {
+		_, err := s.db.Exec(query)
+		if err == nil {
+			return nil
+		}
+		if !isBusy(err) {
+			return &database.Error{OrigErr: err, Err: "transaction commit failed", Query: []byte(query)}
+		}
+		if attempt > retries {
+			return ErrCommitBusy{Attempts: attempt, OrigErr: err}
+		}
+		s.logVerbosePrintf("commit attempt %v failed, database is busy, retrying\n", attempt)
+		time.Sleep(commitRetryInterval)
+	}
+}
+
+// PingWithRetry pings the database up to attempts times, waiting delay
+// in between, for volumes that are mounted after a container started.
+// It returns the error of the last attempt if all of them fail, or
+// ctx.Err() if ctx is done while waiting.
+func (s *Sqlite) PingWithRetry(ctx context.Context, attempts int, delay time.Duration) (err error) {
+	defer s.handleError(&err)
+
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+		if err = s.db.PingContext(ctx); err == nil {
+			return nil
+		}
+		s.logVerbosePrintf("ping attempt %v of %v failed: %v\n", i+1, attempts, err)
+	}
+	if err == nil {
+		return fmt.Errorf("ping: %v attempts", attempts)
+	}
+	return err
+}
+
+// WaitForUnlock blocks until no other connection holds the lock,
+// e.g. to wait for another instance to finish migrating. It tries to start
+// an exclusive transaction and rolls it back until that succeeds or ctx is
+// done, and returns ctx.Err() then. Unlike Lock and Unlock, nothing else
+// is done, such as detaching databases or checking integrity. It returns
+// right away if the lock is held by this driver.
+func (s *Sqlite) WaitForUnlock(ctx context.Context) (err error) {
+	defer s.handleError(&err)
+
+	if s.isLocked {
+		return nil
+	}
+	if s.config.ReadOnly {
+		return ErrReadOnly
+	}
+
+	// fail attempts right away, ctx limits how long to wait
+	previous, err := s.pragma("busy_timeout")
+	if err != nil {
+		return err
+	}
+	if err := s.setPragma("busy_timeout", "0"); err != nil {
+		return err
+	}
+	defer s.setPragma("busy_timeout", previous)
+
+	for {
+		query := `BEGIN EXCLUSIVE`
+		_, err := s.db.Exec(query)
+		if err == nil {
+			query = `ROLLBACK`
+			if _, err := s.db.Exec(query); err != nil {
+				return &database.Error{OrigErr: err, Query: []byte(query)}
+			}
+			return nil
+		}
+		if !isBusy(err) {
+			return &database.Error{OrigErr: err, Err: "try lock failed", Query: []byte(query)}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(waitForUnlockInterval):
+		}
+	}
+}
+
+// Run executes the statements of a migration inside a transaction.
+// ATTACH statements can't be run inside a transaction, they are run
+// before the transaction is opened. DETACH statements run once the
+// outermost transaction is committed, which is when Unlock is called
+// if the database is locked. Migrations consisting of nothing but
+// whitespace and comments, like placeholder down migrations, are skipped.
+//
+// Statements preceded by a "-- migrate:no-tx" comment line, such as VACUUM,
+// run outside of the transaction. The statements before and after them run
+// in transactions of their own, so the migration is no longer atomic.
+// If the database is locked, the lock's transaction is committed for the
+// statement and started again afterwards.
+func (s *Sqlite) Run(migration io.Reader) (err error) {
+	defer s.handleError(&err)
+	return s.run(migration)
+}
+
+// run runs a migration, see Run. The version set by SetCurrentVersion
+// is cleared when it returns, so it can't apply to later migrations.
+func (s *Sqlite) run(migration io.Reader) error {
+	defer func() {
+		s.currentVersion = database.NilVersion
+	}()
+
+	if s.config.VersionDirectives {
+		return s.runStream(migration)
+	}
+	return s.runTimed(migration)
+}
+
+// RunWithResult runs a migration like Run and returns the number of rows
+// changed by each of its statements, in the order they ran. Statements
+// other than INSERT, UPDATE, DELETE, REPLACE and WITH report 0, DDL included.
+// ATTACH and DETACH statements aren't included. If the migration fails,
+// the counts of the statements run before are returned.
+func (s *Sqlite) RunWithResult(migration io.Reader) (rows []int64, err error) {
+	defer s.handleError(&err)
+
+	s.rowsAffected = make([]int64, 0)
+	defer func() {
+		s.rowsAffected = nil
+	}()
+
+	err = s.run(migration)
+	return s.rowsAffected, err
+}
+
+// runTimed runs a migration and records how long it took.
+func (s *Sqlite) runTimed(migration io.Reader) error {
+	start := time.Now()
+	err := s.execMigration(migration)
+	if err != nil {
+		s.logLevelPrintf(LogError, "migration %v failed: %v\n", s.currentVersion, err)
+		return err
+	}
+	duration := time.Since(start)
+	s.logLevelPrintf(LogInfo, "migration %v ran in %v\n", s.currentVersion, duration)
+	if s.currentVersion != database.NilVersion {
+		s.timings = append(s.timings, MigrationTiming{Version: s.currentVersion, Duration: duration})
+	}
+	return nil
+}
+
+func (s *Sqlite) execMigration(migration io.Reader) (err error) {
+	if s.config.ReadOnly {
+		return ErrReadOnly
+	}
+
+	migr, err := s.readMigration(migration)
+	if err != nil {
+		return err
+	}
+
+	// SQLite reports a byte order mark as a syntax error
+	migr = bytes.TrimPrefix(migr, utf8BOM)
+	if !utf8.Valid(migr) {
+		return ErrInvalidEncoding
+	}
+
+	if s.config.ExpandEnv {
+		expanded, err := expandEnv(string(migr), os.LookupEnv, s.config.RelaxedEnv)
+		if err != nil {
+			return err
+		}
+		migr = []byte(expanded)
+	}
+
+	s.checksum = ""
+
+	// placeholders such as empty down migrations have nothing to run
+	if len(tokenize(string(migr))) == 0 {
+		s.logVerbosePrintf("migration %v is empty, skipping it\n", s.currentVersion)
+		return nil
+	}
+
+	if s.config.Checksum {
+		sum := normalizedChecksum(migr)
+		last, err := s.lastChecksum()
+		if err != nil {
+			return err
+		}
+		if sum == last {
+			s.logVerbosePrintf("warning: migration %v is identical to the last migration run, skipping it\n", s.currentVersion)
+			s.checksum = sum
+			return nil
+		}
+		defer func() {
+			if err == nil {
+				s.checksum = sum
+			}
+		}()
+	}
+
+	attach := make([]string, 0)
+	body := make([]batch, 0)
+	detach := make([]string, 0)
+	all := make([]string, 0)
+	stmts, err := s.split(string(migr[:]))
+	if err != nil {
+		return err
+	}
+	for _, stmt := range stmts {
+		all = append(all, stmt)
+		switch statementKeyword(stmt) {
+		case "ATTACH":
+			attach = append(attach, stmt)
+		case "DETACH":
+			detach = append(detach, stmt)
+		default:
+			if s.config.RewriteIfExists {
+				stmt = rewriteIfExists(stmt)
+			}
+			noTx := s.config.NoTx || hasNoTxDirective(stmt)
+			if !noTx {
+				if reason := needsNoTx(stmt); reason != "" {
+					return ErrNeedsNoTx{Statement: stmt, Reason: reason}
+				}
+			}
+			if noTx || len(body) == 0 || body[len(body)-1].noTx {
+				body = append(body, batch{noTx: noTx})
+			}
+			body[len(body)-1].stmts = append(body[len(body)-1].stmts, stmt)
+		}
+	}
+
+	if s.config.SafeMode && !s.config.AllowDestructive {
+		for _, stmt := range all {
+			if isDestructive(stmt) {
+				return ErrDestructive{Statement: stmt}
+			}
+		}
+	}
+
+	if s.config.Lint {
+		for _, stmt := range all {
+			if err := lintStatement(stmt); err != nil {
+				return err
+			}
+		}
+	}
+
+	// migrations rejected before running any statement, by Lint for one,
+	// leave the version as it is
+	defer func() {
+		if err != nil {
+			s.markFailed()
+		}
+	}()
+
+	for _, stmt := range attach {
+		if _, err := s.db.Exec(stmt); err != nil {
+			return s.migrationError(err, stmt)
+		}
+	}
+
+	if s.config.QueryOnly {
+		previous, qerr := s.pragma("query_only")
+		if qerr != nil {
+			return qerr
+		}
+		if qerr := s.setPragma("query_only", "ON"); qerr != nil {
+			return qerr
+		}
+		defer func() {
+			if qerr := s.setPragma("query_only", previous); err == nil {
+				err = qerr
+			}
+		}()
+	}
+
+	for _, b := range body {
+		if b.noTx {
+			err = s.execNoTx(b.stmts[0])
+		} else {
+			err = s.transactionally(func() error {
+				return s.execAll(b.stmts)
+			})
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	s.detach = append(s.detach, detach...)
+	if s.isLocked || s.savepoints > 0 {
+		return err
+	}
+	if derr := s.runDetach(); err == nil {
+		err = derr
+	}
+	return err
+}
+
+// batch is a run of statements of a migration which are executed
+// in the same transaction. A noTx batch holds a single statement
+// which is executed outside of any transaction.
+type batch struct {
+	stmts []string
+	noTx  bool
+}
+
+func (s *Sqlite) execAll(stmts []string) error {
+	for _, stmt := range stmts {
+		if s.config.Explain {
+			if err := s.explain(stmt); err != nil {
+				return s.migrationError(err, stmt)
+			}
+		}
+		s.logLevelPrintf(LogDebug, "exec: %v\n", stmt)
+		result, err := s.db.Exec(stmt)
+		if err != nil {
+			return s.migrationError(err, stmt)
+		}
+		if s.rowsAffected != nil {
+			s.rowsAffected = append(s.rowsAffected, rowsAffected(stmt, result))
+		}
+	}
+	return nil
+}
+
+// rowsAffected returns the number of rows changed by stmt. SQLite
+// reports the count of the last INSERT, UPDATE or DELETE for any other
+// statement, so those report 0.
+func rowsAffected(stmt string, result sql.Result) int64 {
+	switch statementKeyword(stmt) {
+	case "INSERT", "UPDATE", "DELETE", "REPLACE", "WITH":
+	default:
+		return 0
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// execNoTx executes stmt outside of any transaction, see outsideOfLockTx.
+func (s *Sqlite) execNoTx(stmt string) error {
+	if s.savepoints > 0 {
+		return s.migrationError(ErrNoTxInTx, stmt)
+	}
+	return s.outsideOfLockTx(func() error {
+		return s.execAll([]string{stmt})
+	})
+}
+
+// Transaction runs fn inside of a transaction, for migrations written
+// in Go. The transaction is committed if fn returns nil and rolled back
+// otherwise. fn must only use tx, the driver's connection is busy until fn
+// returns. While locked, the transaction of the lock is committed first and
+// started again afterwards, like for migrate:no-tx statements. Transaction
+// can't run inside of RunMany, it returns ErrNoTxInTx then.
+func (s *Sqlite) Transaction(fn func(tx *sql.Tx) error) (err error) {
+	defer s.handleError(&err)
+
+	if s.config.ReadOnly {
+		return ErrReadOnly
+	}
+	if s.savepoints > 0 {
+		return ErrNoTxInTx
+	}
+	return s.outsideOfLockTx(func() error {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return &database.Error{OrigErr: err, Err: "transaction start failed"}
+		}
+		if err := fn(tx); err != nil {
+			if rerr := tx.Rollback(); rerr != nil {
+				return &database.Error{OrigErr: rerr, Err: "transaction rollback failed: " + err.Error()}
+			}
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return &database.Error{OrigErr: err, Err: "transaction commit failed"}
+		}
+		return nil
+	})
+}
+
+// outsideOfLockTx runs fn outside of the transaction of the lock, which
+// is committed before and started again afterwards. The exclusive locking
+// mode keeps other connections out in between. In WAL mode, other writers
+// may get in between.
+func (s *Sqlite) outsideOfLockTx(fn func() error) error {
+	if s.isLocked {
+		query := `COMMIT`
+		if _, err := s.db.Exec(query); err != nil {
+			return &database.Error{OrigErr: err, Err: "transaction commit failed", Query: []byte(query)}
+		}
+	}
+
+	err := fn()
+
+	if s.isLocked {
+		query := `BEGIN EXCLUSIVE`
+		if _, berr := s.db.Exec(query); berr != nil {
+			s.isLocked = false
+			s.db.Exec(`PRAGMA locking_mode = NORMAL`)
+			return &database.Error{OrigErr: berr, Err: "try lock failed", Query: []byte(query)}
+		}
+	}
+	return err
+}
+
+// RunMany runs several migrations inside a single transaction,
+// so either all or none of them are applied.
+func (s *Sqlite) RunMany(migrations ...io.Reader) (err error) {
+	defer s.handleError(&err)
+
+	err = s.transactionally(func() error {
+		for _, migration := range migrations {
+			if err := s.run(migration); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if s.isLocked || s.savepoints > 0 {
+		return err
+	}
+	if derr := s.runDetach(); err == nil {
+		err = derr
+	}
+	return err
+}
+
+// String returns the URL of the database, without query parameters.
+// It can be passed to Open, like sqlite3:///var/lib/app/my%20app.db.
+func (s *Sqlite) String() string {
+	return "sqlite3://" + (&nurl.URL{Path: s.config.DatabaseName}).EscapedPath()
+}
+
+// Compatible reports if d is a driver of this package, for code
+// supporting several databases.
+func Compatible(d database.Driver) bool {
+	_, ok := d.(*Sqlite)
+	return ok
+}
+
+// RunSection runs the migration stored in the n bytes of r starting at
+// offset off, e.g. a member of an archive. It fails without running
+// anything if r ends before the section does.
+func (s *Sqlite) RunSection(r io.ReaderAt, off, n int64) (err error) {
+	defer s.handleError(&err)
+
+	if off < 0 || n < 0 {
+		return fmt.Errorf("invalid section %v+%v", off, n)
+	}
+	if s.config.MaxFileSize > 0 && n > s.config.MaxFileSize {
+		return ErrMigrationTooLarge
+	}
+	migr := make([]byte, n)
+	if _, err := io.ReadFull(io.NewSectionReader(r, off, n), migr); err != nil {
+		return err
+	}
+	return s.run(bytes.NewReader(migr))
+}
+
+// Dialect returns "sqlite3". It implements database.Dialecter.
+func (s *Sqlite) Dialect() string {
+	return "sqlite3"
+}
+
+// SetCurrentVersion sets the version of the migration passed to the next
+// call to Run. If a statement of that migration fails, its version is set
+// dirty, unless a dirty version is set already. It implements
+// database.VersionTracker.
+func (s *Sqlite) SetCurrentVersion(version int) {
+	s.currentVersion = version
+}
+
+// markFailed sets the version of a failed migration dirty, so Version
+// reports the migration that was attempted rather than the one before.
+// A dirty version set before, by migrate for one, is kept.
+func (s *Sqlite) markFailed() {
+	if s.currentVersion == database.NilVersion || s.config.ReadOnly {
+		return
+	}
+	_, dirty, err := s.version(s.db, s.config.MigrationsTable)
+	if err != nil || dirty {
+		return
+	}
+	if err := s.setVersion(s.currentVersion, true); err != nil {
+		s.logVerbosePrintf("warning: can't set failed migration %v dirty: %v\n", s.currentVersion, err)
+	}
+}
+
+// MigrationTiming is how long a migration took to run, see Timings.
+type MigrationTiming struct {
+	Version  int
+	Duration time.Duration
+}
+
+// Timings returns how long the migrations run so far took, in the order
+// they ran. Only migrations whose version was set by SetCurrentVersion
+// and that ran without an error are included.
+func (s *Sqlite) Timings() []MigrationTiming {
+	timings := make([]MigrationTiming, len(s.timings))
+	copy(timings, s.timings)
+	return timings
+}
+
+// readMigration reads a migration completely, up to MaxFileSize bytes.
+func (s *Sqlite) readMigration(r io.Reader) ([]byte, error) {
+	if s.config.MaxFileSize <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	migr, err := ioutil.ReadAll(io.LimitReader(r, s.config.MaxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(migr)) > s.config.MaxFileSize {
+		return nil, ErrMigrationTooLarge
+	}
+	return migr, nil
+}
+
+// migrationError returns a database.Error for a failed statement
+// of the current migration.
+func (s *Sqlite) migrationError(err error, stmt string) error {
+	msg := "migration failed"
+	if s.currentVersion != database.NilVersion {
+		msg = fmt.Sprintf("migration %v failed", s.currentVersion)
+	}
+	return database.Error{OrigErr: err, Err: msg, Query: []byte(stmt)}
+}
+
+// explain logs the query plan of stmt, if it is a query or DML statement.
+// https://www.sqlite.org/eqp.html
+func (s *Sqlite) explain(stmt string) error {
+	if s.config.Log == nil || !s.config.Log.Verbose() {
+		return nil
+	}
+
+	switch statementKeyword(stmt) {
+	case "SELECT", "WITH", "UPDATE", "DELETE":
+	case "INSERT", "REPLACE":
+		hasSelect := false
+		for _, token := range tokenize(stmt) {
+			if token == "SELECT" {
+				hasSelect = true
+				break
+			}
+		}
+		if !hasSelect {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	rows, err := s.db.Query(`EXPLAIN QUERY PLAN ` + stmt)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	s.logVerbosePrintf("EXPLAIN QUERY PLAN %v\n", stmt)
+	for rows.Next() {
+		var id, parent, notused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notused, &detail); err != nil {
+			return err
+		}
+		s.logVerbosePrintf("  %v\n", detail)
+	}
+	return rows.Err()
+}
+
+// SetLogger sets the logger for debug output, see Config.Log.
+func (s *Sqlite) SetLogger(log migrate.Logger) {
+	s.config.Log = log
+}
+
+// split splits a migration into statements, using the splitter set
+// by WithSplitter or, if there is none, StatementMarker.
+func (s *Sqlite) split(migration string) ([]string, error) {
+	if s.splitter != nil {
+		return s.splitter.Split(migration)
+	}
+	if len(s.config.StatementMarker) > 0 {
+		return MarkerSplitter{Marker: s.config.StatementMarker}.Split(migration)
+	}
+	return SmartSplitter{}.Split(migration)
+}
+
+// SetVersion replaces the stored version. In history mode, only the
+// rows of versions >= version are replaced, so the table keeps a row
+// for every version that is still applied.
+//
+// If VersionWriter is set, clean versions are written to it
+// as "version=<N>" lines once stored.
+func (s *Sqlite) SetVersion(version int, dirty bool) (err error) {
+	defer s.handleError(&err)
+	return s.setVersion(version, dirty)
+}
+
+func (s *Sqlite) setVersion(version int, dirty bool) error {
+	if s.config.ReadOnly {
+		return ErrReadOnly
+	}
+
+	current, currentDirty, err := s.version(s.db, s.config.MigrationsTable)
+	if err != nil {
+		return err
+	}
+	if s.config.SafeMode && !s.config.AllowDowngrade && version < current {
+		return ErrDowngradeBlocked
+	}
+
+	// re-runs set versions that are stored already, don't write them
+	// again unless there is a new checksum to store
+	switch {
+	case version == current && dirty == currentDirty && len(s.checksum) == 0:
+		s.logVerbosePrintf("version %v is unchanged, skipping it\n", version)
+	case s.config.History:
+		err = s.setHistoryVersion(version, dirty)
+	default:
+		err = s.upsertVersion(version, dirty)
+	}
+	if err != nil || dirty || s.config.VersionWriter == nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(s.config.VersionWriter, "version=%v\n", version)
+	return err
+}
+
+// upsertVersion upserts the version row before deleting any other row,
+// so the table is never empty in between, not even within the transaction.
+func (s *Sqlite) upsertVersion(version int, dirty bool) error {
+	return s.transactionally(func() error {
+		if version >= 0 && s.config.Checksum {
+			query := `INSERT INTO ` + quoteIdentifier(s.config.MigrationsTable) + ` (version, dirty, checksum)
+				VALUES (?, ?, COALESCE(?, (SELECT checksum FROM ` + quoteIdentifier(s.config.MigrationsTable) + `)))
+				ON CONFLICT (version) DO UPDATE SET dirty = excluded.dirty, checksum = excluded.checksum`
+			if _, err := s.db.Exec(query, version, dirty, s.takeChecksum()); err != nil {
+				return &database.Error{OrigErr: err, Query: []byte(query)}
+			}
+		} else if version >= 0 {
+			query := `INSERT INTO ` + quoteIdentifier(s.config.MigrationsTable) + ` (version, dirty) VALUES (?, ?)
+				ON CONFLICT (version) DO UPDATE SET dirty = excluded.dirty`
+			if _, err := s.db.Exec(query, version, dirty); err != nil {
+				return &database.Error{OrigErr: err, Query: []byte(query)}
+			}
+		}
+
+		query := `DELETE FROM ` + quoteIdentifier(s.config.MigrationsTable) + ` WHERE version != ?`
+		if _, err := s.db.Exec(query, version); err != nil {
+			return &database.Error{OrigErr: err, Query: []byte(query)}
+		}
+		return nil
+	})
+}
+
+func (s *Sqlite) setHistoryVersion(version int, dirty bool) error {
+	return s.transactionally(func() error {
+		// keep the checksum of the latest version, unless there is a new one
+		var sum interface{}
+		if s.config.Checksum {
+			sum = s.takeChecksum()
+			if sum == nil {
+				last, err := s.lastChecksum()
+				if err != nil {
+					return err
+				}
+				if len(last) > 0 {
+					sum = last
+				}
+			}
+		}
+
+		query := `DELETE FROM ` + quoteIdentifier(s.config.MigrationsTable) + ` WHERE version >= ?`
+		if _, err := s.db.Exec(query, version); err != nil {
+			return &database.Error{OrigErr: err, Query: []byte(query)}
+		}
+
+		if version >= 0 && s.config.Checksum {
+			query = `INSERT INTO ` + quoteIdentifier(s.config.MigrationsTable) + ` (version, dirty, applied_at, checksum) VALUES (?, ?, ?, ?)`
+			if _, err := s.db.Exec(query, version, dirty, s.timeNow().UTC(), sum); err != nil {
+				return &database.Error{OrigErr: err, Query: []byte(query)}
+			}
+		} else if version >= 0 {
+			query = `INSERT INTO ` + quoteIdentifier(s.config.MigrationsTable) + ` (version, dirty, applied_at) VALUES (?, ?, ?)`
+			if _, err := s.db.Exec(query, version, dirty, s.timeNow().UTC()); err != nil {
+				return &database.Error{OrigErr: err, Query: []byte(query)}
+			}
+		}
+		return nil
+	})
+}
+
+// Version returns the stored version. In WAL mode it is read using a
+// separate read-only connection, so it returns the last committed version
+// without waiting for migrations running on the driver's connection.
+func (s *Sqlite) Version() (version int, dirty bool, err error) {
+	defer s.handleError(&err)
+	return s.readVersion(s.config.MigrationsTable)
+}
+
+// VersionIn returns the version stored in the migrations table table,
+// to look at the version of another migration stream sharing the
+// database. It works for tables in history mode, too. table may only
+// contain letters, digits and underscores.
+func (s *Sqlite) VersionIn(table string) (version int, dirty bool, err error) {
+	defer s.handleError(&err)
+
+	if !isIdentifierFragment(table) || isInternalTable(table) {
+		return 0, false, fmt.Errorf("invalid migrations table %q", table)
+	}
+	return s.readVersion(table)
+}
+
+// readVersion reads the version of table, using the
+// read-only connection in WAL mode.
+func (s *Sqlite) readVersion(table string) (version int, dirty bool, err error) {
+	if s.wal {
+		reader, err := s.readConn()
+		if err != nil {
+			return 0, false, err
+		}
+		if reader != nil {
+			return s.version(reader, table)
+		}
+	}
+	return s.version(s.db, table)
+}
+
+// readConn returns the read-only connection used by Version, opening it
+// if needed. It returns nil if the database isn't a file.
+func (s *Sqlite) readConn() (*sql.DB, error) {
+	s.readerMu.Lock()
+	defer s.readerMu.Unlock()
+	if s.reader != nil {
+		return s.reader, nil
+	}
+
+	file, err := s.databaseFile()
+	if err != nil {
+		return nil, err
+	}
+	if len(file) == 0 {
+		return nil, nil
+	}
+
+	dsn := s.readerDSN
+	if len(dsn) == 0 {
+		dsn = readOnlyDSN(file)
+	}
+	var reader *sql.DB
+	if s.connectHook != nil {
+		reader = sql.OpenDB(newHookConnector(dsn, s.connectHook))
+	} else if reader, err = sql.Open("sqlite3", dsn); err != nil {
+		return nil, err
+	}
+	reader.SetMaxOpenConns(1)
+	s.reader = reader
+	return reader, nil
+}
+
+// readOnlyDSN returns a DSN opening file read-only, failing if it doesn't exist.
+func readOnlyDSN(file string) string {
+	return fileURI(file) + "?mode=ro"
+}
+
+// fileURI returns file as a URI filename, go-sqlite3 passes the
+// parameters of those on to SQLite.
+// https://www.sqlite.org/uri.html
+func fileURI(file string) string {
+	escaper := strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
+	return "file:" + escaper.Replace(file)
+}
+
+// databaseFile returns the path of the main database file,
+// or an empty string if it isn't a file, as for in-memory databases.
+func (s *Sqlite) databaseFile() (string, error) {
+	var file string
+	query := `SELECT file FROM pragma_database_list WHERE name = 'main'`
+	if err := s.db.QueryRow(query).Scan(&file); err != nil {
+		return "", &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return file, nil
+}
+
+// version reads the latest version of table. Tables in single row
+// mode have one row at most, so the same query works for both modes.
+func (s *Sqlite) version(db *sql.DB, table string) (version int, dirty bool, err error) {
+	query := `SELECT version, dirty FROM ` + quoteIdentifier(table) + ` ORDER BY version DESC LIMIT 1`
+	err = db.QueryRow(query).Scan(&version, &dirty)
+	switch {
+	case err == sql.ErrNoRows:
+		return database.NilVersion, false, nil
+
+	case err != nil:
+		if IsNoSuchTable(err) {
+			return database.NilVersion, false, nil
+		}
+		return 0, false, &database.Error{OrigErr: err, Query: []byte(query)}
+
+	default:
+		return version, dirty, nil
+	}
+}
+
+// Baseline sets the version of an existing database to version without
+// running any migration, to start using migrate with a database
+// whose schema matches version. It returns ErrAlreadyVersioned if a
+// version is set already, unless force is true.
+func (s *Sqlite) Baseline(version int, force bool) (err error) {
+	defer s.handleError(&err)
+
+	if version < 0 {
+		return fmt.Errorf("baseline version must be >= 0, got %v", version)
+	}
+
+	return s.transactionally(func() error {
+		current, _, err := s.version(s.db, s.config.MigrationsTable)
+		if err != nil {
+			return err
+		}
+		if current != database.NilVersion && !force {
+			return ErrAlreadyVersioned
+		}
+		return s.setVersion(version, false)
+	})
+}
+
+// LastAppliedAt returns when the current version was applied.
+// It returns ErrNoHistory if history mode is off or no version
+// has been applied yet. Like Version, it reads the last committed
+// version in WAL mode.
+func (s *Sqlite) LastAppliedAt() (appliedAt time.Time, err error) {
+	defer s.handleError(&err)
+
+	if !s.config.History {
+		return time.Time{}, ErrNoHistory
+	}
+
+	db := s.db
+	if s.wal {
+		reader, err := s.readConn()
+		if err != nil {
+			return time.Time{}, err
+		}
+		if reader != nil {
+			db = reader
+		}
+	}
+
+	query := `SELECT applied_at FROM ` + quoteIdentifier(s.config.MigrationsTable) + ` ORDER BY version DESC LIMIT 1`
+	err = db.QueryRow(query).Scan(&appliedAt)
+	switch {
+	case err == sql.ErrNoRows:
+		return time.Time{}, ErrNoHistory
+
+	case err != nil:
+		return time.Time{}, &database.Error{OrigErr: err, Query: []byte(query)}
+
+	default:
+		return appliedAt, nil
+	}
+}
+
+func (s *Sqlite) Drop() (err error) {
+	defer s.handleError(&err)
+
+	if s.config.ReadOnly {
+		return ErrReadOnly
+	}
+	if s.config.BeforeDrop != nil {
+		if err := s.config.BeforeDrop(); err != nil {
+			return err
+		}
+	}
+
+	// select all tables, except for SQLite's internal ones
+	query := `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite\_%' ESCAPE '\' ORDER BY name`
+	tables, err := s.db.Query(query)
+	if err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	defer tables.Close()
+
+	tableNames := make([]string, 0)
+	for tables.Next() {
+		var tableName string
+		if err := tables.Scan(&tableName); err != nil {
+			return err
+		}
+		if len(tableName) > 0 && !s.keepOnDrop(tableName) {
+			tableNames = append(tableNames, tableName)
+		}
+	}
+	if err := tables.Err(); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	tables.Close()
+
+	// drop referencing tables before the tables they reference
+	tableNames, err = s.dropOrder(tableNames)
+	if err != nil {
+		return err
+	}
+
+	// foreign keys can only be switched off outside of transactions,
+	// within the lock's transaction the drop order has to do
+	if len(tableNames) > 0 && !s.isLocked && s.savepoints == 0 {
+		foreignKeys, err := s.pragma("foreign_keys")
+		if err != nil {
+			return err
+		}
+		if foreignKeys == "1" {
+			if err := s.setPragma("foreign_keys", "OFF"); err != nil {
+				return err
+			}
+			defer func() {
+				if ferr := s.setPragma("foreign_keys", "ON"); err == nil {
+					err = ferr
+				}
+			}()
+		}
+	}
+
+	if len(tableNames) > 0 {
+		err := s.transactionally(func() error {
+			// tables referencing each other can't be ordered,
+			// check their constraints once all are dropped
+			query := `PRAGMA defer_foreign_keys = ON`
+			if _, err := s.db.Exec(query); err != nil {
+				return &database.Error{OrigErr: err, Query: []byte(query)}
+			}
+			for _, t := range tableNames {
+				query = `DROP TABLE IF EXISTS ` + quoteIdentifier(t)
+				if _, err := s.db.Exec(query); err != nil {
+					return &database.Error{OrigErr: err, Query: []byte(query)}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := s.ensureVersionTable(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keepOnDrop reports if table is one of the tables Drop keeps.
+func (s *Sqlite) keepOnDrop(table string) bool {
+	for _, keep := range s.config.DropExcept {
+		if strings.EqualFold(table, keep) {
+			return true
+		}
+	}
+	return false
+}
+
+// transactionally runs fn inside a savepoint. Outside of a transaction
+// a savepoint behaves like BEGIN, when locked it nests inside the lock's
+// transaction. If fn returns an error, everything fn did is rolled back.
+// https://www.sqlite.org/lang_savepoint.html
+func (s *Sqlite) transactionally(fn func() error) error {
+	if s.maxSavepoints > 0 && s.savepoints >= s.maxSavepoints {
+		return ErrSavepointTooDeep
+	}
+	s.savepoints++
+	defer func() {
+		s.savepoints--
+	}()
+	prefix := s.savepointPrefix
+	if len(prefix) == 0 {
+		prefix = DefaultSavepointPrefix
+	}
+	name := fmt.Sprintf("%v_%v", prefix, s.savepoints)
+	if s.savepoints > savepointDepthWarning {
+		s.logVerbosePrintf("savepoint depth %v exceeds %v, are transactions nested by accident?\n", s.savepoints, savepointDepthWarning)
+	}
+
+	query := `SAVEPOINT ` + name
+	s.logVerbosePrintf("%v\n", query)
+	if _, err := s.db.Exec(query); err != nil {
+		return &database.Error{OrigErr: err, Err: "transaction start failed", Query: []byte(query)}
+	}
+
+	if err := fn(); err != nil {
+		// ROLLBACK TO keeps the savepoint open, so release it afterwards
+		query = `ROLLBACK TO ` + name
+		s.logVerbosePrintf("%v\n", query)
+		if _, rerr := s.db.Exec(query); rerr != nil {
+			return &database.Error{OrigErr: rerr, Err: "transaction rollback failed: " + err.Error(), Query: []byte(query)}
+		}
+		s.db.Exec(`RELEASE ` + name)
+		return err
+	}
+
+	query = `RELEASE ` + name
+	s.logVerbosePrintf("%v\n", query)
+	if _, err := s.db.Exec(query); err != nil {
+		return &database.Error{OrigErr: err, Err: "transaction commit failed", Query: []byte(query)}
+	}
+	return nil
+}
+
+// Locked reports if the driver holds the lock: the transaction
+// opened by Lock is still open. See WaitForUnlock to wait for locks
+// held by other connections.
+func (s *Sqlite) Locked() bool {
+	return s.isLocked
+}
+
+// SavepointDepth returns how many savepoints are currently open.
+// The transaction opened by Lock isn't counted.
+func (s *Sqlite) SavepointDepth() int {
+	return s.savepoints
+}
+
+// runDetach runs DETACH statements held back by Run.
+func (s *Sqlite) runDetach() error {
+	for len(s.detach) > 0 {
+		stmt := s.detach[0]
+		s.detach = s.detach[1:]
+		if _, err := s.db.Exec(stmt); err != nil {
+			return s.migrationError(err, stmt)
+		}
+	}
+	return nil
+}
+
+// ensureVersionTableTimeout runs ensureVersionTable, waiting at most
+// Config.InitTimeout for other connections to release their locks.
+func (s *Sqlite) ensureVersionTableTimeout() error {
+	if s.config.InitTimeout <= 0 {
+		return s.ensureVersionTable()
+	}
+
+	// the busy timeout is how long SQLite waits for locks
+	previous, err := s.pragma("busy_timeout")
+	if err != nil {
+		return err
+	}
+	if err := s.setPragma("busy_timeout", strconv.FormatInt(int64(s.config.InitTimeout/time.Millisecond), 10)); err != nil {
+		return err
+	}
+
+	err = s.ensureVersionTable()
+	if e, ok := err.(*database.Error); ok && isBusy(e.OrigErr) {
+		err = ErrInitTimeout
+	}
+
+	if perr := s.setPragma("busy_timeout", previous); err == nil {
+		err = perr
+	}
+	return err
+}
+
+func (s *Sqlite) ensureVersionTable() error {
+	// check if migration table exists
+	var count int
+	query := `SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = ?`
+	if err := s.db.QueryRow(query, s.config.MigrationsTable).Scan(&count); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	if count == 1 {
+		if err := s.checkVersionTable(); err != nil {
+			return err
+		}
+	}
+	if s.config.ReadOnly {
+		return nil
+	}
+	if count == 1 {
+		if s.config.History {
+			if err := s.ensureVersionIndex(); err != nil {
+				return err
+			}
+		}
+		if s.config.Checksum {
+			return s.ensureChecksumColumn()
+		}
+		return nil
+	}
+
+	// if not, create the empty migration table
+	columns := `version bigint not null primary key, dirty boolean not null`
+	if s.config.History {
+		columns = `version bigint not null unique, dirty boolean not null, applied_at datetime not null`
+	}
+	if s.config.Checksum {
+		columns += `, checksum text`
+	}
+	query = `CREATE TABLE ` + quoteIdentifier(s.config.MigrationsTable) + ` (` + columns + `)`
+	if _, err := s.db.Exec(query); err != nil {
+		return &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return nil
+}
+
+// runInitSQL runs the statements of Config.InitSQL on the connection of
+// instance. Drivers opened by OpenWithOptions run them on every
+// connection instead, see withConnectHook.
+func (s *Sqlite) runInitSQL() error {
+	if len(s.config.InitSQL) == 0 || s.connectHook != nil {
+		return nil
+	}
+	stmts, err := s.initStatements()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range stmts {
+		if _, err := s.db.Exec(stmt); err != nil {
+			return fmt.Errorf("init sql %v: %v in %q", s.config.InitSQL, err, stmt)
+		}
+	}
+	return nil
+}
+
+// initStatements reads Config.InitSQL and splits it into statements.
+func (s *Sqlite) initStatements() ([]string, error) {
+	content, err := ioutil.ReadFile(s.config.InitSQL)
+	if err != nil {
+		return nil, fmt.Errorf("init sql: %v", err)
+	}
+	stmts, err := s.split(string(bytes.TrimPrefix(content, utf8BOM)))
+	if err != nil {
+		return nil, fmt.Errorf("init sql %v: %v", s.config.InitSQL, err)
+	}
+	return stmts, nil
+}
+
+// timeNow returns the current time as set by WithTimeNow.
+func (s *Sqlite) timeNow() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+func (s *Sqlite) logVerbosePrintf(format string, v ...interface{}) {
+	if s.config.Log != nil && s.config.Log.Verbose() {
+		s.config.Log.Printf(format, v...)
+	}
+}
+
+// quoteLiteral quotes a string literal such as a pragma value.
+func quoteLiteral(value string) string {
+	return `'` + strings.Replace(value, `'`, `''`, -1) + `'`
+}
+
+// quoteIdentifier quotes an identifier, e.g. a table name.
+// https://www.sqlite.org/lang_keywords.html
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// parseBool parses a boolean URL query value. An empty value is false.
+func parseBool(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "":
+		return false, nil
+	case "on", "yes":
+		return true, nil
+	case "off", "no":
+		return false, nil
+	}
+	return strconv.ParseBool(v)
+}
+
+// parseInt parses an integer URL query value, which must be >= min.
+// An empty value returns nil.
+func parseInt(v string, min int64) (*int64, error) {
+	if len(v) == 0 {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if n < min {
+		return nil, fmt.Errorf("%v is less than %v", n, min)
+	}
+	return &n, nil
+}
+
+// isBusy reports if err is caused by another connection holding a lock.
+func isBusy(err error) bool {
+	if e, ok := err.(sqlite3.Error); ok {
+		return e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+// IsNoSuchTable reports if err is caused by a statement referring to
+// a table that doesn't exist, e.g. a down migration that ran before.
+func IsNoSuchTable(err error) bool {
+	if e, ok := sqliteError(err); ok {
+		return e.Code == sqlite3.ErrError && strings.HasPrefix(e.Error(), "no such table")
+	}
+	return false
+}
+
+// IsNoSuchColumn reports if err is caused by a statement referring to
+// a column that doesn't exist.
+func IsNoSuchColumn(err error) bool {
+	if e, ok := sqliteError(err); ok {
+		return e.Code == sqlite3.ErrError && strings.HasPrefix(e.Error(), "no such column")
+	}
+	return false
+}
+
+// sqliteError returns the sqlite3.Error err is caused by,
+// unwrapping any database.Error.
+func sqliteError(err error) (sqlite3.Error, bool) {
+	for {
+		switch e := err.(type) {
+		case sqlite3.Error:
+			return e, true
+		case database.Error:
+			err = e.OrigErr
+		case *database.Error:
+			if e == nil {
+				return sqlite3.Error{}, false
+			}
+			err = e.OrigErr
+		default:
+			return sqlite3.Error{}, false
+		}
+	}
+}
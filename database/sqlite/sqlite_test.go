@@ -0,0 +1,1742 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattes/migrate/database"
+	dt "github.com/mattes/migrate/database/testing"
+	"github.com/mattn/go-sqlite3"
+)
+
+// tempDir returns a temporary directory and a func to remove it again.
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "sqlite-driver-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// open opens a driver for a fresh database in dir.
+// query is appended to the URL, e.g. "?x-migrations-table=foo".
+func open(t *testing.T, dir, query string) *Sqlite {
+	p := &Sqlite{}
+	d, err := p.Open("sqlite3://" + filepath.Join(dir, "sqlite.db") + query)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return d.(*Sqlite)
+}
+
+// testLogger collects verbose log output.
+type testLogger struct {
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *testLogger) Verbose() bool {
+	return true
+}
+
+func (l *testLogger) contains(substr string) bool {
+	for _, line := range l.lines {
+		if strings.Contains(line, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func tableExists(t *testing.T, s *Sqlite, schema, name string) bool {
+	var count int
+	query := `SELECT COUNT(1) FROM ` + schema + `.sqlite_master WHERE type = 'table' AND name = ?`
+	if err := s.db.QueryRow(query, name).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count == 1
+}
+
+func Test(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+	dt.Test(t, d, []byte("CREATE TABLE t (Qty int, Name string);"))
+}
+
+func TestRunEmpty(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+	log := &testLogger{}
+	d.config.Log = log
+
+	for _, migration := range []string{"", " \n\t\r\n", "-- nothing to undo\n", "/* placeholder */\n-- down\n"} {
+		log.lines = nil
+		if err := d.Run(bytes.NewReader([]byte(migration))); err != nil {
+			t.Fatalf("expected %q to succeed, got %v", migration, err)
+		}
+		if !log.contains("is empty") || log.contains("SAVEPOINT") {
+			t.Fatalf("expected %q to be skipped, got %q", migration, log.lines)
+		}
+	}
+}
+
+func TestMultiStatement(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+	if err := d.Run(bytes.NewReader([]byte("CREATE TABLE foo (foo text); CREATE TABLE bar (bar text);"))); err != nil {
+		t.Fatalf("expected err to be nil, got %v", err)
+	}
+
+	// make sure second table exists
+	if !tableExists(t, d, "main", "bar") {
+		t.Fatalf("expected table bar to exist")
+	}
+}
+
+func TestRunRollback(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+	if err := d.Run(bytes.NewReader([]byte("CREATE TABLE foo (foo text); CREATE TABLE foo (foo text);"))); err == nil {
+		t.Fatal("expected err not to be nil")
+	}
+	if tableExists(t, d, "main", "foo") {
+		t.Fatalf("expected table foo to be rolled back")
+	}
+}
+
+func TestFilterCustomQuery(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?x-custom=foobar")
+	d.Close()
+}
+
+func TestOpenDirectory(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	if _, err := (&Sqlite{}).Open("sqlite3://" + dir); err != ErrPathIsDirectory {
+		t.Fatalf("expected ErrPathIsDirectory, got %v", err)
+	}
+}
+
+func TestWithMigrationsTable(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?x-migrations-table=my_migrations")
+	defer d.Close()
+	if !tableExists(t, d, "main", "my_migrations") {
+		t.Fatalf("expected table my_migrations to exist")
+	}
+	if err := d.SetVersion(3, false); err != nil {
+		t.Fatal(err)
+	}
+	version, _, err := d.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 3 {
+		t.Fatal("expected version 3")
+	}
+}
+
+func TestAttachDetach(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	other := filepath.Join(dir, "other.db")
+	migration := `
+		ATTACH DATABASE '` + other + `' AS other;
+		CREATE TABLE other.foo (foo text);
+		INSERT INTO other.foo (foo) VALUES ('bar');
+		DETACH DATABASE other;`
+
+	for _, locked := range []bool{false, true} {
+		os.Remove(other)
+		d := open(t, dir, "")
+
+		if locked {
+			if err := d.Lock(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := d.Run(bytes.NewReader([]byte(migration))); err != nil {
+			t.Fatalf("expected err to be nil, got %v", err)
+		}
+		if locked {
+			if err := d.Unlock(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// other must be detached again
+		var n int
+		if err := d.db.QueryRow(`SELECT COUNT(1) FROM pragma_database_list WHERE name = 'other'`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Fatalf("locked=%v: expected database other to be detached", locked)
+		}
+
+		// and the table must have been created in the attached database
+		if _, err := d.db.Exec(`ATTACH DATABASE ? AS other`, other); err != nil {
+			t.Fatal(err)
+		}
+		if !tableExists(t, d, "other", "foo") {
+			t.Fatalf("locked=%v: expected table other.foo to exist", locked)
+		}
+		if tableExists(t, d, "main", "foo") {
+			t.Fatalf("locked=%v: expected table foo not to exist in main", locked)
+		}
+		d.Close()
+		os.Remove(filepath.Join(dir, "sqlite.db"))
+	}
+}
+
+func TestHistory(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?x-history=on")
+	defer d.Close()
+	dt.Test(t, d, []byte("CREATE TABLE t (Qty int, Name string);"))
+
+	for _, v := range []int{3, 4, 5} {
+		if err := d.SetVersion(v, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.SetVersion(4, false); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	if err := d.db.QueryRow(`SELECT COUNT(1) FROM schema_migrations`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	// 2 from dt.Test, 3 and 4
+	if count != 4 {
+		t.Fatalf("expected 4 history rows, got %v", count)
+	}
+	version, _, err := d.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 4 {
+		t.Fatalf("expected version 4, got %v", version)
+	}
+}
+
+func TestHistoryUniqueVersion(t *testing.T) {
+	for _, existing := range []bool{false, true} {
+		dir, cleanup := tempDir(t)
+		defer cleanup()
+
+		if existing {
+			// history tables used to be created without a unique version
+			db, err := sql.Open("sqlite3", filepath.Join(dir, "sqlite.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Exec(`CREATE TABLE schema_migrations (version bigint not null, dirty boolean not null, applied_at datetime not null)`); err != nil {
+				t.Fatal(err)
+			}
+			db.Close()
+		}
+
+		d := open(t, dir, "?x-history=on")
+		defer d.Close()
+		if err := d.SetVersion(1, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.SetVersion(2, false); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := d.db.Exec(`INSERT INTO schema_migrations (version, dirty, applied_at) VALUES (1, 0, 'now')`)
+		if e, ok := err.(sqlite3.Error); !ok || e.ExtendedCode != sqlite3.ErrConstraintUnique {
+			t.Errorf("existing %v: expected a unique constraint error, got %v", existing, err)
+		}
+	}
+}
+
+func TestLastAppliedAt(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	if _, err := d.LastAppliedAt(); err != ErrNoHistory {
+		t.Fatalf("expected ErrNoHistory without history mode, got %v", err)
+	}
+	d.Close()
+	os.Remove(filepath.Join(dir, "sqlite.db"))
+
+	d = open(t, dir, "?x-history=on")
+	defer d.Close()
+	if _, err := d.LastAppliedAt(); err != ErrNoHistory {
+		t.Fatalf("expected ErrNoHistory for empty history, got %v", err)
+	}
+
+	older := time.Date(2017, 1, 1, 10, 0, 0, 0, time.UTC)
+	newer := time.Date(2017, 2, 1, 10, 0, 0, 0, time.UTC)
+	query := `INSERT INTO schema_migrations (version, dirty, applied_at) VALUES (?, ?, ?)`
+	if _, err := d.db.Exec(query, 1, false, older); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.db.Exec(query, 2, false, newer); err != nil {
+		t.Fatal(err)
+	}
+
+	appliedAt, err := d.LastAppliedAt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !appliedAt.Equal(newer) {
+		t.Fatalf("expected %v, got %v", newer, appliedAt)
+	}
+}
+
+func TestRunFailureSetsDirty(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+	if err := d.SetVersion(2, false); err != nil {
+		t.Fatal(err)
+	}
+
+	d.SetCurrentVersion(3)
+	if err := d.Run(bytes.NewReader([]byte("CREATE TABLE foo (foo text); CREATE TABLE foo (foo text);"))); err == nil {
+		t.Fatal("expected err not to be nil")
+	}
+	if version, dirty, err := d.Version(); err != nil || version != 3 || !dirty {
+		t.Fatalf("expected the attempted version 3 to be dirty, got %v (dirty %v, %v)", version, dirty, err)
+	}
+	if tableExists(t, d, "main", "foo") {
+		t.Fatalf("expected table foo to be rolled back")
+	}
+
+	// a dirty version set before running is kept, like the target
+	// version migrate sets for down migrations
+	if err := d.SetVersion(4, true); err != nil {
+		t.Fatal(err)
+	}
+	d.SetCurrentVersion(5)
+	if err := d.Run(bytes.NewReader([]byte("CREATE TABLE foo (foo text); CREATE TABLE foo (foo text);"))); err == nil {
+		t.Fatal("expected err not to be nil")
+	}
+	if version, dirty, err := d.Version(); err != nil || version != 4 || !dirty {
+		t.Fatalf("expected dirty version 4 to be kept, got %v (dirty %v, %v)", version, dirty, err)
+	}
+}
+
+func TestRunFailureClearsCurrentVersion(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+	if err := d.SetVersion(2, false); err != nil {
+		t.Fatal(err)
+	}
+
+	d.SetCurrentVersion(3)
+	if err := d.Run(bytes.NewReader([]byte("CREATE TABLE foo (foo text);"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetVersion(3, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// the version of the migration before doesn't apply to this one
+	if err := d.Run(bytes.NewReader([]byte("SELECT * FROM missing;"))); err == nil {
+		t.Fatal("expected err not to be nil")
+	}
+	if version, dirty, err := d.Version(); err != nil || version != 3 || dirty {
+		t.Fatalf("expected clean version 3, got %v (dirty %v, %v)", version, dirty, err)
+	}
+}
+
+func TestRunRejectedKeepsVersion(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	for _, tc := range []struct {
+		query     string
+		migration string
+	}{
+		{"?x-lint=true", "SELECT 1::int;"},
+		{"?x-safe-mode=true", "DROP TABLE foo;"},
+		{"?x-max-file-size=8", "CREATE TABLE foo (foo text);"},
+		{"", "CREATE TABLE foo (foo text); \xff"},
+		{"", "VACUUM;"},
+	} {
+		d := open(t, dir, tc.query)
+		if err := d.SetVersion(2, false); err != nil {
+			t.Fatal(err)
+		}
+		d.SetCurrentVersion(3)
+		if err := d.Run(bytes.NewReader([]byte(tc.migration))); err == nil {
+			t.Fatalf("expected %q to be rejected with %v", tc.migration, tc.query)
+		}
+		if version, dirty, err := d.Version(); err != nil || version != 2 || dirty {
+			t.Fatalf("expected clean version 2 after %q, got %v (dirty %v, %v)", tc.migration, version, dirty, err)
+		}
+		d.Close()
+	}
+}
+
+func TestRunEncoding(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	bom := append([]byte{0xEF, 0xBB, 0xBF}, []byte("CREATE TABLE foo (foo text);")...)
+	if err := d.Run(bytes.NewReader(bom)); err != nil {
+		t.Fatalf("expected err to be nil, got %v", err)
+	}
+	if !tableExists(t, d, "main", "foo") {
+		t.Fatalf("expected table foo to exist")
+	}
+
+	invalid := []byte("CREATE TABLE bar (bar text DEFAULT '\xff\xfe');")
+	if err := d.Run(bytes.NewReader(invalid)); err != ErrInvalidEncoding {
+		t.Fatalf("expected ErrInvalidEncoding, got %v", err)
+	}
+	if tableExists(t, d, "main", "bar") {
+		t.Fatalf("expected table bar not to exist")
+	}
+}
+
+func TestRunMaxFileSize(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	migration := []byte("CREATE TABLE foo (id int);")
+	d := open(t, dir, fmt.Sprintf("?x-max-file-size=%v", len(migration)))
+	defer d.Close()
+
+	if err := d.Run(bytes.NewReader(migration)); err != nil {
+		t.Fatalf("expected err to be nil, got %v", err)
+	}
+
+	tooLarge := []byte("CREATE TABLE bar (id int);\n")
+	if err := d.Run(bytes.NewReader(tooLarge)); err != ErrMigrationTooLarge {
+		t.Fatalf("expected ErrMigrationTooLarge, got %v", err)
+	}
+	if tableExists(t, d, "main", "bar") {
+		t.Fatalf("expected table bar not to exist")
+	}
+
+	// sections are rejected before they are read
+	if err := d.RunSection(bytes.NewReader(tooLarge), 0, 1<<40); err != ErrMigrationTooLarge {
+		t.Fatalf("expected ErrMigrationTooLarge, got %v", err)
+	}
+}
+
+func TestRunWithResult(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	rows, err := d.RunWithResult(bytes.NewReader([]byte(`CREATE TABLE foo (id int);
+		INSERT INTO foo VALUES (1), (2), (3);
+		UPDATE foo SET id = id * 10 WHERE id > 1;
+		CREATE INDEX foo_idx ON foo (id);`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []int64{0, 3, 2, 0}; !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("expected rows affected %v, got %v", expected, rows)
+	}
+
+	rows, err = d.RunWithResult(bytes.NewReader([]byte(`DELETE FROM foo WHERE id = 1; DELETE FROM bar;`)))
+	if !IsNoSuchTable(err) {
+		t.Fatalf("expected a no such table error, got %v", err)
+	}
+	if expected := []int64{1}; !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("expected rows affected %v, got %v", expected, rows)
+	}
+}
+
+func TestRunRewriteIfExists(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?x-if-exists=rewrite")
+	defer d.Close()
+
+	up := []byte("CREATE TABLE foo (id int); CREATE INDEX foo_idx ON foo (id); CREATE VIEW foo_view AS SELECT id FROM foo;")
+	down := []byte("DROP VIEW foo_view; DROP INDEX foo_idx; DROP TABLE foo;")
+	for _, migration := range [][]byte{up, up, down, down} {
+		if err := d.Run(bytes.NewReader(migration)); err != nil {
+			t.Fatalf("expected err to be nil, got %v", err)
+		}
+	}
+	if tableExists(t, d, "main", "foo") {
+		t.Fatalf("expected table foo to be dropped")
+	}
+}
+
+func TestIsNoSuchTableOrColumn(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	if err := d.Run(bytes.NewReader([]byte(`CREATE TABLE foo (id int)`))); err != nil {
+		t.Fatal(err)
+	}
+
+	err := d.Run(bytes.NewReader([]byte(`DROP TABLE bar`)))
+	if !IsNoSuchTable(err) || IsNoSuchColumn(err) {
+		t.Errorf("expected a no such table error, got %v", err)
+	}
+	err = d.Run(bytes.NewReader([]byte(`UPDATE foo SET bar = 1`)))
+	if !IsNoSuchColumn(err) || IsNoSuchTable(err) {
+		t.Errorf("expected a no such column error, got %v", err)
+	}
+	err = d.Run(bytes.NewReader([]byte(`CREATE TABLE foo (id int)`)))
+	if err == nil || IsNoSuchTable(err) || IsNoSuchColumn(err) {
+		t.Errorf("expected a table already exists error, got %v", err)
+	}
+	if IsNoSuchTable(nil) || IsNoSuchColumn(nil) {
+		t.Errorf("expected nil not to be a no such table or column error")
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	if err := d.Baseline(5, false); err != nil {
+		t.Fatal(err)
+	}
+	version, dirty, err := d.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 5 || dirty {
+		t.Fatalf("expected clean version 5, got %v (dirty: %v)", version, dirty)
+	}
+
+	if err := d.Baseline(6, false); err != ErrAlreadyVersioned {
+		t.Fatalf("expected ErrAlreadyVersioned, got %v", err)
+	}
+
+	if err := d.Baseline(6, true); err != nil {
+		t.Fatal(err)
+	}
+	version, _, err = d.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 6 {
+		t.Fatalf("expected version 6, got %v", version)
+	}
+}
+
+func TestSavepointDepth(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+	log := &testLogger{}
+	d.config.Log = log
+
+	if depth := d.SavepointDepth(); depth != 0 {
+		t.Fatalf("expected depth 0, got %v", depth)
+	}
+	err := d.transactionally(func() error {
+		return d.transactionally(func() error {
+			if depth := d.SavepointDepth(); depth != 2 {
+				return fmt.Errorf("expected depth 2, got %v", depth)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if depth := d.SavepointDepth(); depth != 0 {
+		t.Fatalf("expected depth 0, got %v", depth)
+	}
+	if log.contains("savepoint depth") {
+		t.Fatalf("expected no warning, got %q", log.lines)
+	}
+
+	// nest beyond the warning threshold
+	var nest func(n int) error
+	nest = func(n int) error {
+		if n == 0 {
+			return nil
+		}
+		return d.transactionally(func() error {
+			return nest(n - 1)
+		})
+	}
+	if err := nest(savepointDepthWarning + 1); err != nil {
+		t.Fatal(err)
+	}
+	if !log.contains(fmt.Sprintf("savepoint depth %v", savepointDepthWarning+1)) {
+		t.Fatalf("expected warning about savepoint depth, got %q", log.lines)
+	}
+}
+
+func TestStatementMarker(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?x-statement-marker="+url.QueryEscape("-- +migrate StatementEnd"))
+	defer d.Close()
+
+	migration := `
+CREATE TABLE foo (foo text);
+-- +migrate StatementEnd
+CREATE TABLE bar (bar text)
+-- +migrate StatementEnd
+`
+	if err := d.Run(bytes.NewReader([]byte(migration))); err != nil {
+		t.Fatalf("expected err to be nil, got %v", err)
+	}
+	if !tableExists(t, d, "main", "bar") {
+		t.Fatalf("expected table bar to exist")
+	}
+}
+
+func TestSetCurrentVersion(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+	var _ database.VersionTracker = d
+
+	d.SetCurrentVersion(42)
+	err := d.Run(bytes.NewReader([]byte("CREATE TABLE foo (foo text); SELECT * FROM missing;")))
+	if err == nil {
+		t.Fatal("expected err not to be nil")
+	}
+	if !strings.Contains(err.Error(), "migration 42 failed") {
+		t.Fatalf("expected error to contain the version, got %v", err)
+	}
+}
+
+func TestRunMany(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	err := d.RunMany(
+		bytes.NewReader([]byte("CREATE TABLE foo (foo text);")),
+		bytes.NewReader([]byte("CREATE TABLE bar (bar text); SELECT * FROM missing;")),
+		bytes.NewReader([]byte("CREATE TABLE baz (baz text);")),
+	)
+	if err == nil {
+		t.Fatal("expected err not to be nil")
+	}
+	for _, table := range []string{"foo", "bar", "baz"} {
+		if tableExists(t, d, "main", table) {
+			t.Fatalf("expected table %v to be rolled back", table)
+		}
+	}
+
+	err = d.RunMany(
+		bytes.NewReader([]byte("CREATE TABLE foo (foo text);")),
+		bytes.NewReader([]byte("CREATE TABLE bar (bar text);")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"foo", "bar"} {
+		if !tableExists(t, d, "main", table) {
+			t.Fatalf("expected table %v to exist", table)
+		}
+	}
+}
+
+func TestOpenEncodedPath(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	for _, name := range []string{"my app.db", "a+b.db", "c#d.db", "e%f.db", "g?h.db"} {
+		path := filepath.Join(dir, name)
+		u := &url.URL{Scheme: "sqlite3", Path: path}
+
+		p := &Sqlite{}
+		d, err := p.Open(u.String())
+		if err != nil {
+			t.Fatalf("%v: %v", u, err)
+		}
+		d.Close()
+
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("%v: expected database at %v, got %v", u, path, err)
+		}
+	}
+}
+
+func TestWaitForUnlock(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	leader := open(t, dir, "")
+	defer leader.Close()
+	follower := open(t, dir, "")
+	defer follower.Close()
+
+	if err := leader.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := follower.WaitForUnlock(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	unlocked := make(chan error, 1)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		unlocked <- leader.Unlock()
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := follower.WaitForUnlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-unlocked; err != nil {
+		t.Fatal(err)
+	}
+	if err := follower.Lock(); err != nil {
+		t.Fatalf("expected the lock to be available, got %v", err)
+	}
+	if err := follower.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// waiting doesn't detach the databases Unlock would detach
+	if _, err := follower.db.Exec(`ATTACH DATABASE ? AS other`, filepath.Join(dir, "other.db")); err != nil {
+		t.Fatal(err)
+	}
+	follower.detach = []string{"DETACH DATABASE other"}
+	if err := follower.WaitForUnlock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(follower.detach) != 1 {
+		t.Fatalf("expected the pending DETACH to be kept, got %v", follower.detach)
+	}
+	var n int
+	if err := follower.db.QueryRow(`SELECT COUNT(*) FROM pragma_database_list WHERE name = 'other'`).Scan(&n); err != nil || n != 1 {
+		t.Fatalf("expected other to stay attached, got %v (%v)", n, err)
+	}
+}
+
+func TestLocked(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	if d.Locked() {
+		t.Fatalf("expected the driver not to be locked")
+	}
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if !d.Locked() {
+		t.Fatalf("expected the driver to be locked")
+	}
+	if err := d.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if d.Locked() {
+		t.Fatalf("expected the driver to be unlocked")
+	}
+}
+
+func TestLockingMode(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if mode, err := d.pragma("locking_mode"); err != nil || mode != "exclusive" {
+		t.Fatalf("expected locking mode exclusive, got %v (%v)", mode, err)
+	}
+	if err := d.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if mode, err := d.pragma("locking_mode"); err != nil || mode != "normal" {
+		t.Fatalf("expected locking mode normal, got %v (%v)", mode, err)
+	}
+}
+
+func TestUnlockCommitBusy(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?_busy_timeout=10&x-commit-retries=1")
+	defer d.Close()
+	log := &testLogger{}
+	d.config.Log = log
+
+	// a reader keeps the database busy, COMMIT can't get an exclusive lock
+	reader, err := sql.Open("sqlite3", filepath.Join(dir, "sqlite.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	tx, err := reader.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM sqlite_master`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+
+	// a deferred transaction writing next to the reader
+	if _, err := d.db.Exec(`BEGIN`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.db.Exec(`CREATE TABLE foo (id int)`); err != nil {
+		t.Fatal(err)
+	}
+	d.isLocked = true
+
+	err = d.Unlock()
+	if e, ok := err.(ErrCommitBusy); !ok || e.Attempts != 2 {
+		t.Fatalf("expected ErrCommitBusy after 2 attempts, got %v", err)
+	}
+	if !d.isLocked {
+		t.Fatalf("expected the database to stay locked")
+	}
+
+	retries := int64(10)
+	d.config.CommitRetries = &retries
+	log.lines = nil
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		tx.Rollback()
+	}()
+	if err := d.Unlock(); err != nil {
+		t.Fatalf("expected the commit to succeed once the reader is done, got %v", err)
+	}
+	if !log.contains("commit attempt 1 failed, database is busy") {
+		t.Fatalf("expected the commit to be retried, got %q", log.lines)
+	}
+	if !tableExists(t, d, "main", "foo") {
+		t.Fatalf("expected table foo to be committed")
+	}
+}
+
+// flakyConnector fails to connect the first failures times.
+type flakyConnector struct {
+	dsn      string
+	failures int
+}
+
+func (c *flakyConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if c.failures > 0 {
+		c.failures--
+		return nil, fmt.Errorf("volume not mounted yet")
+	}
+	return c.Driver().Open(c.dsn)
+}
+
+func (c *flakyConnector) Driver() driver.Driver {
+	return &sqlite3.SQLiteDriver{}
+}
+
+func TestPingWithRetry(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	db := sql.OpenDB(&flakyConnector{dsn: filepath.Join(dir, "sqlite.db"), failures: 1})
+	defer db.Close()
+	log := &testLogger{}
+	d := &Sqlite{db: db, config: &Config{Log: log}}
+
+	if err := d.PingWithRetry(context.Background(), 2, 10*time.Millisecond); err != nil {
+		t.Fatalf("expected the second attempt to succeed, got %v", err)
+	}
+	if !log.contains("ping attempt 1 of 2 failed: volume not mounted yet") {
+		t.Fatalf("expected the first attempt to fail, got %q", log.lines)
+	}
+
+	db.Close()
+	db = sql.OpenDB(&flakyConnector{dsn: filepath.Join(dir, "sqlite.db"), failures: 3})
+	d.db = db
+	if err := d.PingWithRetry(context.Background(), 2, 10*time.Millisecond); err == nil || err.Error() != "volume not mounted yet" {
+		t.Fatalf("expected the error of the last attempt, got %v", err)
+	}
+}
+
+func TestInitTimeout(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	// hold a write lock on the database with another connection
+	path := filepath.Join(dir, "sqlite.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`CREATE TABLE foo (foo text)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`BEGIN IMMEDIATE`); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Sqlite{}
+	started := time.Now()
+	_, err = p.Open("sqlite3://" + path + "?_busy_timeout=30000&x-init-timeout=200ms")
+	if err != ErrInitTimeout {
+		t.Fatalf("expected ErrInitTimeout, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("expected Open to fail fast, took %v", elapsed)
+	}
+
+	if _, err := db.Exec(`COMMIT`); err != nil {
+		t.Fatal(err)
+	}
+	d, err := p.Open("sqlite3://" + path + "?x-init-timeout=1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+}
+
+func TestExplain(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?x-explain=on")
+	defer d.Close()
+	log := &testLogger{}
+	d.SetLogger(log)
+
+	migration := `
+		CREATE TABLE foo (id int, name text);
+		CREATE INDEX foo_name ON foo (name);
+		INSERT INTO foo (id, name) VALUES (1, 'a');
+		INSERT INTO foo (id, name) SELECT id + 1, 'b' FROM foo WHERE name = 'a';`
+	if err := d.Run(bytes.NewReader([]byte(migration))); err != nil {
+		t.Fatal(err)
+	}
+
+	if !log.contains("EXPLAIN QUERY PLAN INSERT INTO foo (id, name) SELECT") {
+		t.Fatalf("expected query plan of INSERT ... SELECT, got %q", log.lines)
+	}
+	if !log.contains("USING INDEX foo_name") {
+		t.Fatalf("expected query plan to use index foo_name, got %q", log.lines)
+	}
+	for _, stmt := range []string{"CREATE", "INSERT INTO foo (id, name) VALUES"} {
+		if log.contains("EXPLAIN QUERY PLAN " + stmt) {
+			t.Fatalf("expected no query plan for %v, got %q", stmt, log.lines)
+		}
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	for _, locked := range []bool{false, true} {
+		if locked {
+			if err := d.Lock(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		table := fmt.Sprintf("foo_%v", locked)
+		err := d.Transaction(func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE ` + table + ` (id int)`)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("locked %v: %v", locked, err)
+		}
+
+		rollback := fmt.Errorf("rollback")
+		err = d.Transaction(func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`INSERT INTO ` + table + ` VALUES (1)`); err != nil {
+				return err
+			}
+			return rollback
+		})
+		if err != rollback {
+			t.Fatalf("locked %v: expected the error of fn, got %v", locked, err)
+		}
+
+		if locked {
+			if !d.isLocked {
+				t.Fatalf("expected the lock to be kept")
+			}
+			if err := d.Unlock(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !tableExists(t, d, "main", table) {
+			t.Fatalf("locked %v: expected table %v to be committed", locked, table)
+		}
+		var count int
+		if err := d.db.QueryRow(`SELECT COUNT(1) FROM ` + table).Scan(&count); err != nil || count != 0 {
+			t.Fatalf("locked %v: expected the insert to be rolled back, got %v rows (%v)", locked, count, err)
+		}
+	}
+
+	err := d.transactionally(func() error {
+		return d.Transaction(func(tx *sql.Tx) error {
+			return nil
+		})
+	})
+	if err != ErrNoTxInTx {
+		t.Fatalf("expected ErrNoTxInTx inside of a savepoint, got %v", err)
+	}
+}
+
+func TestRunNoTx(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	migration := "CREATE TABLE foo (foo int); INSERT INTO foo VALUES (1);\n" +
+		"-- migrate:no-tx\nVACUUM;\n" +
+		"INSERT INTO foo VALUES (2);"
+
+	if err := d.Run(bytes.NewReader([]byte("VACUUM;"))); err == nil {
+		t.Fatal("expected VACUUM to fail inside of a transaction")
+	}
+	if err := d.Run(bytes.NewReader([]byte(migration))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Run(bytes.NewReader([]byte("INSERT INTO foo VALUES (3);\n-- migrate:no-tx\nVACUUM;\nINSERT INTO foo VALUES (4);"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM foo`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Fatalf("expected 4 rows, got %v", count)
+	}
+
+	// the statements after a failed no-tx statement don't run
+	if err := d.Run(bytes.NewReader([]byte("-- migrate:no-tx\nSELECT * FROM missing;\nINSERT INTO foo VALUES (5);"))); err == nil {
+		t.Fatal("expected err not to be nil")
+	}
+	err := d.RunMany(
+		bytes.NewReader([]byte("INSERT INTO foo VALUES (5);")),
+		bytes.NewReader([]byte("-- migrate:no-tx\nVACUUM;")),
+	)
+	if e, ok := err.(database.Error); !ok || e.OrigErr != ErrNoTxInTx {
+		t.Fatalf("expected ErrNoTxInTx, got %v", err)
+	}
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM foo`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Fatalf("expected 4 rows, got %v", count)
+	}
+}
+
+func TestRunNeedsNoTx(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	for _, stmt := range []string{
+		"VACUUM",
+		"vacuum main",
+		"VACUUM INTO 'copy.db'",
+		"PRAGMA wal_checkpoint",
+		"PRAGMA main.wal_checkpoint(TRUNCATE)",
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA main.journal_mode=DELETE",
+		"PRAGMA foreign_keys = ON",
+		"PRAGMA foreign_keys(OFF)",
+	} {
+		err := d.Run(bytes.NewReader([]byte("CREATE TABLE bar (bar int);\n" + stmt + ";")))
+		if e, ok := err.(ErrNeedsNoTx); !ok || e.Statement != stmt+";" {
+			t.Fatalf("%v: expected ErrNeedsNoTx, got %v", stmt, err)
+		}
+		if !strings.Contains(err.Error(), noTxDirective) || !strings.Contains(err.Error(), "x-no-tx") {
+			t.Fatalf("%v: expected the error to suggest %q and x-no-tx, got %v", stmt, noTxDirective, err)
+		}
+		// nothing runs
+		if tableExists(t, d, "main", "bar") {
+			t.Fatalf("%v: expected bar not to be created", stmt)
+		}
+	}
+
+	for _, stmt := range []string{
+		"PRAGMA journal_mode",
+		"PRAGMA foreign_keys",
+		"PRAGMA incremental_vacuum",
+		"PRAGMA main.incremental_vacuum(10)",
+		"SELECT 'VACUUM'",
+		"-- migrate:no-tx\nVACUUM",
+		"-- migrate:no-tx\nPRAGMA foreign_keys = ON",
+	} {
+		if err := d.Run(bytes.NewReader([]byte(stmt + ";"))); err != nil {
+			t.Fatalf("%v: %v", stmt, err)
+		}
+	}
+
+	// x-no-tx runs every statement outside of a transaction
+	noTx := open(t, dir, "?x-no-tx=on")
+	defer noTx.Close()
+	if err := noTx.Run(bytes.NewReader([]byte("CREATE TABLE baz (baz text); VACUUM; PRAGMA foreign_keys = ON;"))); err != nil {
+		t.Fatal(err)
+	}
+	if !tableExists(t, noTx, "main", "baz") {
+		t.Fatal("expected table baz to be created")
+	}
+}
+
+func TestDropForeignKeys(t *testing.T) {
+	migrations := []string{
+		// parent created first
+		`CREATE TABLE a_parent (id integer primary key);
+		CREATE TABLE b_child (id integer primary key, parent_id integer REFERENCES a_parent (id));`,
+		// child created first
+		`CREATE TABLE b_child (id integer primary key, parent_id integer REFERENCES A_PARENT (id));
+		CREATE TABLE a_parent (id integer primary key);`,
+		// referencing each other
+		`CREATE TABLE a_parent (id integer primary key, child_id integer REFERENCES b_child (id));
+		CREATE TABLE b_child (id integer primary key, parent_id integer REFERENCES a_parent (id));`,
+	}
+
+	for i, migration := range migrations {
+		dir, cleanup := tempDir(t)
+		d := open(t, dir, "?_foreign_keys=on")
+
+		if err := d.Run(bytes.NewReader([]byte(migration + `
+			INSERT OR IGNORE INTO a_parent (id) VALUES (1);
+			INSERT OR IGNORE INTO b_child (id, parent_id) VALUES (2, 1);`))); err != nil {
+			t.Fatalf("%v: %v", i, err)
+		}
+		// foreign keys can't be switched off within the lock's transaction
+		if err := d.Lock(); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Drop(); err != nil {
+			t.Errorf("%v: %v", i, err)
+		}
+		if err := d.Unlock(); err != nil {
+			t.Fatal(err)
+		}
+		for _, table := range []string{"a_parent", "b_child"} {
+			if tableExists(t, d, "main", table) {
+				t.Errorf("%v: expected table %v to be dropped", i, table)
+			}
+		}
+
+		d.Close()
+		cleanup()
+	}
+}
+
+func TestDropRestoresForeignKeys(t *testing.T) {
+	for _, foreignKeys := range []string{"0", "1"} {
+		dir, cleanup := tempDir(t)
+		d := open(t, dir, "?_foreign_keys="+foreignKeys)
+
+		if err := d.Run(bytes.NewReader([]byte(`CREATE TABLE a_parent (id integer primary key);
+			CREATE TABLE b_child (id integer primary key, parent_id integer REFERENCES a_parent (id));
+			INSERT INTO a_parent VALUES (1);
+			INSERT INTO b_child VALUES (2, 1);`))); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Drop(); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := d.pragma("foreign_keys"); err != nil || value != foreignKeys {
+			t.Errorf("expected foreign_keys to be %v after Drop, got %v (%v)", foreignKeys, value, err)
+		}
+
+		d.Close()
+		cleanup()
+	}
+}
+
+func TestEchoVersion(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	for _, history := range []bool{false, true} {
+		d := open(t, dir, fmt.Sprintf("?x-migrations-table=history_%v&x-history=%v", history, history))
+		var out bytes.Buffer
+		d.config.VersionWriter = &out
+
+		for _, v := range []struct {
+			version int
+			dirty   bool
+		}{{1, true}, {1, false}, {2, true}, {2, false}, {1, false}} {
+			if err := d.SetVersion(v.version, v.dirty); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if expected := "version=1\nversion=2\nversion=1\n"; out.String() != expected {
+			t.Errorf("history %v: expected %q, got %q", history, expected, out.String())
+		}
+		d.Close()
+	}
+}
+
+func TestVersionWAL(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?_journal_mode=wal")
+	defer d.Close()
+
+	if err := d.SetVersion(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetVersion(2, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// read while a long migration runs on the locked connection
+	done := make(chan error, 1)
+	go func() {
+		done <- d.Run(bytes.NewReader([]byte(`CREATE TABLE foo AS
+			WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 1000000) SELECT i FROM n;`)))
+	}()
+
+	reads := 0
+	for running := true; running; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			running = false
+		default:
+		}
+
+		version, dirty, err := d.Version()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != 1 || dirty {
+			t.Fatalf("expected last committed version 1, got %v (dirty %v)", version, dirty)
+		}
+		reads++
+	}
+	if reads < 2 {
+		t.Fatalf("expected Version not to wait for the migration, got %v reads", reads)
+	}
+
+	if err := d.SetVersion(2, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if version, _, err := d.Version(); err != nil || version != 2 {
+		t.Fatalf("expected version 2, got %v (%v)", version, err)
+	}
+}
+
+func TestCheckpointOnClose(t *testing.T) {
+	for _, checkpoint := range []bool{false, true} {
+		dir, cleanup := tempDir(t)
+		defer cleanup()
+
+		other, err := sql.Open("sqlite3", filepath.Join(dir, "sqlite.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer other.Close()
+		if _, err := other.Exec(`PRAGMA journal_mode = WAL`); err != nil {
+			t.Fatal(err)
+		}
+
+		query := "?_journal_mode=wal"
+		if checkpoint {
+			query += "&x-checkpoint-on-close=on"
+		}
+		d := open(t, dir, query)
+		if err := d.Run(bytes.NewReader([]byte(`CREATE TABLE foo (id int); INSERT INTO foo VALUES (1);`))); err != nil {
+			t.Fatal(err)
+		}
+		// another connection reading the database keeps the WAL from
+		// being removed when the driver is closed
+		var n int
+		if err := other.QueryRow(`SELECT count(*) FROM foo`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		fi, err := os.Stat(filepath.Join(dir, "sqlite.db-wal"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checkpoint && fi.Size() != 0 {
+			t.Errorf("expected WAL to be truncated, got %v bytes", fi.Size())
+		}
+		if !checkpoint && fi.Size() == 0 {
+			t.Errorf("expected WAL not to be truncated without x-checkpoint-on-close")
+		}
+	}
+}
+
+func TestLastAppliedAtWAL(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?_journal_mode=wal&x-history=on")
+	defer d.Close()
+
+	applied := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	d.now = func() time.Time { return applied }
+	if err := d.SetVersion(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	d.now = func() time.Time { return applied.Add(time.Hour) }
+	if err := d.SetVersion(2, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// the version set while locked isn't committed yet
+	appliedAt, err := d.LastAppliedAt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !appliedAt.Equal(applied) {
+		t.Fatalf("expected the last committed %v, got %v", applied, appliedAt)
+	}
+	if err := d.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVersionWALReaderParams(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?_journal_mode=wal&_busy_timeout=1234")
+	defer d.Close()
+
+	if err := d.SetVersion(1, false); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := d.readConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the reader is opened with the driver's parameters
+	var timeout int
+	if err := reader.QueryRow(`PRAGMA busy_timeout`).Scan(&timeout); err != nil {
+		t.Fatal(err)
+	}
+	if timeout != 1234 {
+		t.Fatalf("expected busy timeout 1234, got %v", timeout)
+	}
+	if _, err := reader.Exec(`CREATE TABLE foo (foo int)`); err == nil {
+		t.Fatal("expected the reader to be read-only")
+	}
+	if version, _, err := d.Version(); err != nil || version != 1 {
+		t.Fatalf("expected version 1, got %v (%v)", version, err)
+	}
+}
+
+func TestSetVersionUnchanged(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?_journal_mode=wal")
+	defer d.Close()
+	log := &testLogger{}
+	d.config.Log = log
+
+	if err := d.SetVersion(3, false); err != nil {
+		t.Fatal(err)
+	}
+	if !log.contains("SAVEPOINT") {
+		t.Fatalf("expected version 3 to be written, got %q", log.lines)
+	}
+
+	log.lines = nil
+	if err := d.SetVersion(3, false); err != nil {
+		t.Fatal(err)
+	}
+	if log.contains("SAVEPOINT") || !log.contains("version 3 is unchanged") {
+		t.Fatalf("expected the unchanged version not to be written, got %q", log.lines)
+	}
+
+	log.lines = nil
+	if err := d.SetVersion(3, true); err != nil {
+		t.Fatal(err)
+	}
+	if !log.contains("SAVEPOINT") {
+		t.Fatalf("expected dirty version 3 to be written, got %q", log.lines)
+	}
+	if version, dirty, err := d.Version(); err != nil || version != 3 || !dirty {
+		t.Fatalf("expected dirty version 3, got %v (dirty %v, %v)", version, dirty, err)
+	}
+}
+
+func TestSetVersionNeverEmpty(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?_journal_mode=wal")
+	defer d.Close()
+	if err := d.SetVersion(0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := sql.Open("sqlite3", filepath.Join(dir, "sqlite.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	ready := make(chan bool)
+	stop := make(chan bool)
+	done := make(chan int, 1)
+	go func() {
+		defer close(done)
+		reads := 0
+		for {
+			if reads == 1 {
+				close(ready)
+			}
+			select {
+			case <-stop:
+				done <- reads
+				return
+			default:
+			}
+			var count int
+			if err := reader.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&count); err != nil {
+				t.Error(err)
+				return
+			}
+			if count != 1 {
+				t.Errorf("expected exactly one version row, got %v", count)
+				return
+			}
+			reads++
+		}
+	}()
+
+	select {
+	case <-ready:
+	case reads := <-done:
+		t.Fatalf("reader stopped after %v reads", reads)
+	}
+	for i := 1; i <= 200; i++ {
+		if err := d.SetVersion(i, true); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.SetVersion(i, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	<-done
+
+	if version, dirty, err := d.Version(); err != nil || version != 200 || dirty {
+		t.Fatalf("expected version 200, got %v (dirty %v, %v)", version, dirty, err)
+	}
+}
+
+func TestDropExcept(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?x-drop-except=countries,%20Currencies")
+	defer d.Close()
+
+	if err := d.Run(bytes.NewReader([]byte(`CREATE TABLE countries (code text); INSERT INTO countries VALUES ('NZ');
+		CREATE TABLE currencies (code text); INSERT INTO currencies VALUES ('NZD');
+		CREATE TABLE users (name text);`))); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Drop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if tableExists(t, d, "main", "users") {
+		t.Fatal("expected table users to be dropped")
+	}
+	for _, table := range []string{"countries", "currencies"} {
+		var count int
+		if err := d.db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Fatalf("expected table %v to keep its row, got %v rows", table, count)
+		}
+	}
+
+	for _, except := range []string{"countries,", "foo%20bar", "a-b"} {
+		if _, err := (&Sqlite{}).Open(fmt.Sprintf("sqlite3://%v?x-drop-except=%v", filepath.Join(dir, "sqlite.db"), except)); err == nil {
+			t.Errorf("expected x-drop-except=%v to fail", except)
+		}
+	}
+}
+
+func TestBeforeDrop(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	if err := d.Run(bytes.NewReader([]byte("CREATE TABLE foo (foo text);"))); err != nil {
+		t.Fatal(err)
+	}
+
+	veto := fmt.Errorf("not in production")
+	called := 0
+	d.config.BeforeDrop = func() error {
+		called++
+		return veto
+	}
+	if err := d.Drop(); err != veto {
+		t.Fatalf("expected the veto, got %v", err)
+	}
+	if called != 1 {
+		t.Fatalf("expected BeforeDrop to be called once, got %v", called)
+	}
+	if !tableExists(t, d, "main", "foo") {
+		t.Fatal("expected table foo to be kept")
+	}
+
+	d.config.BeforeDrop = func() error { return nil }
+	if err := d.Drop(); err != nil {
+		t.Fatal(err)
+	}
+	if tableExists(t, d, "main", "foo") {
+		t.Fatal("expected table foo to be dropped")
+	}
+}
+
+func TestTimings(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	// without a version, runs aren't recorded
+	if err := d.Run(bytes.NewReader([]byte("SELECT 1;"))); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, version := range []int{1, 2} {
+		d.SetCurrentVersion(version)
+		if err := d.Run(bytes.NewReader([]byte(fmt.Sprintf("CREATE TABLE foo%v (foo text);", version)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.SetCurrentVersion(3)
+	if err := d.Run(bytes.NewReader([]byte("SELECT * FROM missing;"))); err == nil {
+		t.Fatal("expected err not to be nil")
+	}
+
+	timings := d.Timings()
+	if len(timings) != 2 {
+		t.Fatalf("expected 2 timings, got %+v", timings)
+	}
+	for i, timing := range timings {
+		if timing.Version != i+1 || timing.Duration <= 0 {
+			t.Errorf("unexpected timing %+v", timing)
+		}
+	}
+}
+
+func TestDialect(t *testing.T) {
+	var d database.Driver = &Sqlite{}
+	dialecter, ok := d.(database.Dialecter)
+	if !ok {
+		t.Fatal("expected Sqlite to implement database.Dialecter")
+	}
+	if dialect := dialecter.Dialect(); dialect != "sqlite3" {
+		t.Fatalf("expected dialect sqlite3, got %v", dialect)
+	}
+}
+
+func TestVersionIn(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	app := open(t, dir, "?x-migrations-table=app_migrations")
+	defer app.Close()
+	plugin := open(t, dir, "?x-migrations-table=plugin_migrations&x-history=on")
+	defer plugin.Close()
+
+	if err := app.SetVersion(3, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range []int{1, 2} {
+		if err := plugin.SetVersion(version, version == 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tt := []struct {
+		table   string
+		version int
+		dirty   bool
+	}{
+		{"app_migrations", 3, false},
+		{"plugin_migrations", 2, true},
+		{"missing_migrations", database.NilVersion, false},
+	}
+	for _, v := range tt {
+		version, dirty, err := app.VersionIn(v.table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != v.version || dirty != v.dirty {
+			t.Errorf("%v: expected version %v (dirty %v), got %v (dirty %v)", v.table, v.version, v.dirty, version, dirty)
+		}
+	}
+
+	for _, table := range []string{"", "sqlite_master", "foo; DROP TABLE app_migrations"} {
+		if _, _, err := app.VersionIn(table); err == nil {
+			t.Errorf("expected table %q to be rejected", table)
+		}
+	}
+}
+
+func TestRunSection(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	archive := strings.NewReader("garbage before CREATE TABLE foo (foo text); INSERT INTO foo VALUES ('bar'); garbage after")
+	off := int64(len("garbage before "))
+	n := int64(len("CREATE TABLE foo (foo text); INSERT INTO foo VALUES ('bar');"))
+	if err := d.RunSection(archive, off, n); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM foo`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 row, got %v", count)
+	}
+
+	if err := d.RunSection(archive, archive.Size()-5, 10); err == nil {
+		t.Fatal("expected a section past the end to fail")
+	}
+	if err := d.RunSection(archive, -1, 10); err == nil {
+		t.Fatal("expected a negative offset to fail")
+	}
+}
+
+func TestStringAndCompatible(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	for _, name := range []string{"sqlite.db", "my app.db", "b#c%d.db"} {
+		path := filepath.Join(dir, name)
+		d, err := (&Sqlite{}).Open("sqlite3://" + (&url.URL{Path: path}).EscapedPath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := d.(*Sqlite)
+
+		reopened, err := (&Sqlite{}).Open(s.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if db := reopened.(*Sqlite).config.DatabaseName; db != path {
+			t.Errorf("expected %v to open %v, got %v", s.String(), path, db)
+		}
+		if !Compatible(reopened) {
+			t.Error("expected driver to be compatible")
+		}
+		reopened.Close()
+		d.Close()
+	}
+
+	if Compatible(nil) {
+		t.Error("expected nil not to be compatible")
+	}
+}
@@ -0,0 +1,129 @@
+package sqlite
+
+import (
+	"fmt"
+
+	"github.com/mattes/migrate/database"
+)
+
+// DriverStatus describes the state of a database, see Status.
+type DriverStatus struct {
+	// Version is the current version, NilVersion if there is none
+	Version int
+	Dirty   bool
+
+	MigrationsTable string
+	DatabaseName    string
+
+	// Path is the absolute path of the database file,
+	// empty for in-memory databases
+	Path string
+}
+
+// Status returns the current version along with the migrations table and
+// database it is read from, for display. It doesn't lock the database
+// and works in read-only mode. The driver doesn't know about the migrations
+// of a source, so pending migrations have to be looked up by the caller.
+func (s *Sqlite) Status() (status DriverStatus, err error) {
+	defer s.handleError(&err)
+
+	version, dirty, err := s.readVersion(s.config.MigrationsTable)
+	if err != nil {
+		return DriverStatus{}, err
+	}
+
+	path, err := s.databaseFile()
+	if err != nil {
+		return DriverStatus{}, err
+	}
+
+	return DriverStatus{
+		Version:         version,
+		Dirty:           dirty,
+		MigrationsTable: s.config.MigrationsTable,
+		DatabaseName:    s.config.DatabaseName,
+		Path:            path,
+	}, nil
+}
+
+// ErrDirty is returned by AssertClean if the database is dirty.
+type ErrDirty struct {
+	Version int
+}
+
+func (e ErrDirty) Error() string {
+	return fmt.Sprintf("database is dirty at version %v", e.Version)
+}
+
+// AssertClean returns ErrDirty if the database is dirty, e.g. to check
+// the database before starting a migration run. Like Status, it doesn't
+// lock the database.
+func (s *Sqlite) AssertClean() (err error) {
+	defer s.handleError(&err)
+
+	version, dirty, err := s.readVersion(s.config.MigrationsTable)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return ErrDirty{Version: version}
+	}
+	return nil
+}
+
+// ErrVersionTooLow is returned by CheckVersionAtLeast if the database
+// is below the required version.
+type ErrVersionTooLow struct {
+	Version int
+	Min     int
+}
+
+func (e ErrVersionTooLow) Error() string {
+	if e.Version == database.NilVersion {
+		return fmt.Sprintf("database has no version, need at least %v", e.Min)
+	}
+	return fmt.Sprintf("database version %v is below %v", e.Version, e.Min)
+}
+
+// CheckVersionAtLeast returns ErrVersionTooLow if the version is below min
+// and ErrDirty if it is dirty, for readiness probes. The version is
+// read using a read-only connection unless the database is in-memory, it
+// never locks the database or writes to it.
+func (s *Sqlite) CheckVersionAtLeast(min int) (err error) {
+	defer s.handleError(&err)
+
+	db, err := s.readConn()
+	if err != nil {
+		return err
+	}
+	if db == nil {
+		db = s.db
+	}
+
+	version, dirty, err := s.version(db, s.config.MigrationsTable)
+	if err != nil {
+		return err
+	}
+	if version == database.NilVersion || version < min {
+		return ErrVersionTooLow{Version: version, Min: min}
+	}
+	if dirty {
+		return ErrDirty{Version: version}
+	}
+	return nil
+}
+
+// DataVersion returns PRAGMA data_version, which changes whenever another
+// connection commits, such as an external migration. Polling it tells if the
+// database changed since the last call. Commits of the driver itself don't
+// change it.
+// https://www.sqlite.org/pragma.html#pragma_data_version
+func (s *Sqlite) DataVersion() (version int64, err error) {
+	defer s.handleError(&err)
+
+	query := `PRAGMA data_version`
+	if err := s.db.QueryRow(query).Scan(&version); err != nil {
+		return 0, &database.Error{OrigErr: err, Query: []byte(query)}
+	}
+	return version, nil
+}
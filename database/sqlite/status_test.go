@@ -0,0 +1,160 @@
+package sqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/mattes/migrate/database"
+)
+
+func TestStatus(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?x-migrations-table=foo_migrations")
+	defer d.Close()
+
+	status, err := d.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != database.NilVersion || status.Dirty {
+		t.Fatalf("expected NilVersion, got %+v", status)
+	}
+
+	if err := d.Run(bytes.NewReader([]byte("CREATE TABLE foo (foo text);"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetVersion(3, true); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "sqlite.db")
+	expected := DriverStatus{
+		Version:         3,
+		Dirty:           true,
+		MigrationsTable: "foo_migrations",
+		DatabaseName:    path,
+		Path:            path,
+	}
+	if status, err = d.Status(); err != nil {
+		t.Fatal(err)
+	}
+	if status != expected {
+		t.Fatalf("expected %+v, got %+v", expected, status)
+	}
+
+	// read-only
+	r, err := (&Sqlite{}).Open(fmt.Sprintf("sqlite3://%v?x-migrations-table=foo_migrations&x-read-only=on", path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if status, err = r.(*Sqlite).Status(); err != nil {
+		t.Fatal(err)
+	}
+	if status != expected {
+		t.Fatalf("expected %+v, got %+v", expected, status)
+	}
+}
+
+func TestAssertClean(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	if err := d.AssertClean(); err != nil {
+		t.Fatalf("expected empty database to be clean, got %v", err)
+	}
+	if err := d.SetVersion(2, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AssertClean(); err != nil {
+		t.Fatalf("expected clean version 2, got %v", err)
+	}
+
+	if err := d.SetVersion(3, true); err != nil {
+		t.Fatal(err)
+	}
+	err := d.AssertClean()
+	if e, ok := err.(ErrDirty); !ok || e.Version != 3 {
+		t.Fatalf("expected ErrDirty at version 3, got %v", err)
+	}
+}
+
+func TestCheckVersionAtLeast(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	if err := d.SetVersion(3, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, min := range []int{1, 3} {
+		if err := d.CheckVersionAtLeast(min); err != nil {
+			t.Fatalf("expected version 3 to be at least %v, got %v", min, err)
+		}
+	}
+	err := d.CheckVersionAtLeast(4)
+	if e, ok := err.(ErrVersionTooLow); !ok || e.Version != 3 || e.Min != 4 {
+		t.Fatalf("expected ErrVersionTooLow, got %v", err)
+	}
+
+	if err := d.SetVersion(3, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CheckVersionAtLeast(3); err != (ErrDirty{Version: 3}) {
+		t.Fatalf("expected ErrDirty, got %v", err)
+	}
+
+	// a missing migrations table isn't created
+	if _, err := d.db.Exec(`DROP TABLE ` + d.config.MigrationsTable); err != nil {
+		t.Fatal(err)
+	}
+	err = d.CheckVersionAtLeast(0)
+	if e, ok := err.(ErrVersionTooLow); !ok || e.Version != database.NilVersion {
+		t.Fatalf("expected ErrVersionTooLow, got %v", err)
+	}
+	if tableExists(t, d, "main", d.config.MigrationsTable) {
+		t.Fatal("expected the migrations table not to be created")
+	}
+}
+
+func TestDataVersion(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	before, err := d.DataVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetVersion(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if own, err := d.DataVersion(); err != nil || own != before {
+		t.Fatalf("expected own commits to keep data version %v, got %v (%v)", before, own, err)
+	}
+
+	other, err := sql.Open("sqlite3", filepath.Join(dir, "sqlite.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err := other.Exec(`CREATE TABLE foo (id int)`); err != nil {
+		t.Fatal(err)
+	}
+
+	if after, err := d.DataVersion(); err != nil || after == before {
+		t.Fatalf("expected the data version to change after an external commit, got %v (%v)", after, err)
+	}
+}
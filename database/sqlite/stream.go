@@ -0,0 +1,88 @@
+package sqlite
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mattes/migrate/database"
+)
+
+// versionDirective starts a block of a migration stream, see
+// Config.VersionDirectives.
+const versionDirective = "-- +version"
+
+// versionBlock is a migration of a migration stream.
+type versionBlock struct {
+	version int
+	sql     string
+}
+
+// parseVersionBlocks splits a migration stream into migrations at lines
+// consisting of a "-- +version N" directive only. The block following a
+// directive is the migration of version N. Versions must be ascending and
+// nothing but whitespace and comments may come before the first directive.
+// It returns no blocks if the stream has no directives.
+func parseVersionBlocks(stream string) ([]versionBlock, error) {
+	blocks := make([]versionBlock, 0)
+	lines := strings.SplitAfter(stream, "\n")
+
+	start := 0
+	offset := 0
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0]+" "+fields[1] == versionDirective {
+			version, err := strconv.Atoi(fields[2])
+			if err != nil || version < 0 {
+				return nil, fmt.Errorf("line %v: invalid version directive %q", i+1, strings.TrimSpace(line))
+			}
+
+			if n := len(blocks); n > 0 {
+				if version <= blocks[n-1].version {
+					return nil, fmt.Errorf("line %v: version %v isn't after %v", i+1, version, blocks[n-1].version)
+				}
+				blocks[n-1].sql = stream[start:offset]
+			} else if len(tokenize(stream[:offset])) > 0 {
+				return nil, fmt.Errorf("line %v: statements before the first version directive", i+1)
+			}
+
+			blocks = append(blocks, versionBlock{version: version})
+			start = offset + len(line)
+		}
+		offset += len(line)
+	}
+
+	if n := len(blocks); n > 0 {
+		blocks[n-1].sql = stream[start:]
+	}
+	return blocks, nil
+}
+
+// runStream applies the migrations of a stream with version directives,
+// or runs it as a single migration if it has none. A stream with
+// directives and a version set by SetCurrentVersion, as migrate sets it, is
+// rejected with ErrVersionedStream: both would set the version.
+func (s *Sqlite) runStream(stream io.Reader) error {
+	migr, err := s.readMigration(stream)
+	if err != nil {
+		return err
+	}
+	blocks, err := parseVersionBlocks(string(bytes.TrimPrefix(migr, utf8BOM)))
+	if err != nil {
+		return err
+	}
+	if len(blocks) == 0 {
+		return s.runTimed(bytes.NewReader(migr))
+	}
+	if s.currentVersion != database.NilVersion {
+		return ErrVersionedStream
+	}
+
+	migrations := make([]Migration, 0, len(blocks))
+	for _, b := range blocks {
+		migrations = append(migrations, Migration{Version: b.version, SQL: strings.NewReader(b.sql)})
+	}
+	return s.applyInOrder(migrations)
+}
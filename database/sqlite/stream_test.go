@@ -0,0 +1,150 @@
+package sqlite
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestParseVersionBlocks(t *testing.T) {
+	tt := []struct {
+		stream   string
+		expected []versionBlock
+		fails    bool
+	}{
+		{"", []versionBlock{}, false},
+		{"CREATE TABLE foo (foo text);", []versionBlock{}, false},
+		{"-- +version 1\nCREATE TABLE foo (foo text);\n", []versionBlock{{1, "CREATE TABLE foo (foo text);\n"}}, false},
+		{
+			"-- all migrations\n\n-- +version 1\nSELECT 1;\n  -- +version  3  \r\nSELECT 3;\n-- +version 10\n",
+			[]versionBlock{{1, "SELECT 1;\n"}, {3, "SELECT 3;\n"}, {10, ""}},
+			false,
+		},
+		{"SELECT 1; -- +version 1\nSELECT 2;", []versionBlock{}, false},
+		{"SELECT 0;\n-- +version 1\nSELECT 1;", nil, true},
+		{"-- +version 2\nSELECT 2;\n-- +version 1\nSELECT 1;", nil, true},
+		{"-- +version 1\n-- +version 1\n", nil, true},
+		{"-- +version one\nSELECT 1;", nil, true},
+		{"-- +version -1\nSELECT 1;", nil, true},
+	}
+
+	for i, v := range tt {
+		blocks, err := parseVersionBlocks(v.stream)
+		if v.fails {
+			if err == nil {
+				t.Errorf("%v: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(blocks, v.expected) {
+			t.Errorf("%v: expected %+v, got %+v", i, v.expected, blocks)
+		}
+	}
+}
+
+func TestRunVersionDirectives(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?x-version-directives=on&x-history=on")
+	defer d.Close()
+
+	stream := `-- +version 1
+CREATE TABLE foo (foo text);
+-- +version 2
+INSERT INTO foo VALUES ('bar');
+-- +version 5
+CREATE TABLE baz (baz text);
+`
+	if err := d.Run(bytes.NewReader([]byte(stream))); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := d.db.Query(`SELECT version, dirty FROM schema_migrations ORDER BY rowid`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type state struct {
+		version int
+		dirty   bool
+	}
+	history := make([]state, 0)
+	for rows.Next() {
+		var s state
+		if err := rows.Scan(&s.version, &s.dirty); err != nil {
+			t.Fatal(err)
+		}
+		history = append(history, s)
+	}
+	rows.Close()
+	expected := []state{{1, false}, {2, false}, {5, false}}
+	if !reflect.DeepEqual(history, expected) {
+		t.Fatalf("expected versions %v, got %v", expected, history)
+	}
+
+	// a failing block leaves its version dirty
+	stream = "-- +version 6\nCREATE TABLE qux (qux text);\n-- +version 7\nINSERT INTO missing VALUES (1);\n"
+	if err := d.Run(bytes.NewReader([]byte(stream))); err == nil {
+		t.Fatal("expected the stream to fail")
+	}
+	if version, dirty, err := d.Version(); err != nil || version != 7 || !dirty {
+		t.Fatalf("expected dirty version 7, got %v, %v, %v", version, dirty, err)
+	}
+
+	// migrate sets the version of the migration it runs, the directives
+	// would set others
+	d.SetCurrentVersion(8)
+	stream = "-- +version 9\nCREATE TABLE quux (quux text);\n"
+	if err := d.Run(bytes.NewReader([]byte(stream))); err != ErrVersionedStream {
+		t.Fatalf("expected ErrVersionedStream, got %v", err)
+	}
+	if tableExists(t, d, "main", "quux") {
+		t.Fatal("expected table quux not to be created")
+	}
+	if version, dirty, err := d.Version(); err != nil || version != 7 || !dirty {
+		t.Fatalf("expected dirty version 7 to be kept, got %v, %v, %v", version, dirty, err)
+	}
+
+	// streams join the transaction of RunMany, a failure rolls back
+	// their versions too
+	err = d.RunMany(
+		bytes.NewReader([]byte("-- +version 10\nCREATE TABLE ten (ten text);\n-- +version 11\nCREATE TABLE eleven (eleven text);\n")),
+		bytes.NewReader([]byte("CREATE TABLE twelve (twelve text);")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, dirty, err := d.Version(); err != nil || version != 11 || dirty {
+		t.Fatalf("expected clean version 11, got %v, %v, %v", version, dirty, err)
+	}
+	for _, table := range []string{"ten", "eleven", "twelve"} {
+		if !tableExists(t, d, "main", table) {
+			t.Fatalf("expected table %v to be created", table)
+		}
+	}
+	err = d.RunMany(
+		bytes.NewReader([]byte("-- +version 13\nCREATE TABLE thirteen (thirteen text);\n")),
+		bytes.NewReader([]byte("SELECT * FROM missing;")),
+	)
+	if err == nil {
+		t.Fatal("expected RunMany to fail")
+	}
+	if version, dirty, err := d.Version(); err != nil || version != 11 || dirty {
+		t.Fatalf("expected version 11 to be kept, got %v, %v, %v", version, dirty, err)
+	}
+	if tableExists(t, d, "main", "thirteen") {
+		t.Fatal("expected table thirteen to be rolled back")
+	}
+
+	// migrations without directives run as usual
+	if err := d.Run(bytes.NewReader([]byte("CREATE TABLE plain (plain text);"))); err != nil {
+		t.Fatal(err)
+	}
+	if !tableExists(t, d, "main", "plain") {
+		t.Fatal("expected table plain to be created")
+	}
+}
@@ -0,0 +1,31 @@
+package sqlite
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// ErrTemplate is returned by RunTemplate if the template can't be
+// rendered. Nothing is run then.
+type ErrTemplate struct {
+	Name    string
+	OrigErr error
+}
+
+func (e ErrTemplate) Error() string {
+	return fmt.Sprintf("render migration template %v: %v", e.Name, e.OrigErr)
+}
+
+// RunTemplate renders tmpl with data and runs the result like Run does,
+// e.g. for generated schemas. Values are inserted as they are, they have
+// to be quoted by the template.
+func (s *Sqlite) RunTemplate(tmpl *template.Template, data interface{}) (err error) {
+	defer s.handleError(&err)
+
+	var migration bytes.Buffer
+	if err := tmpl.Execute(&migration, data); err != nil {
+		return ErrTemplate{Name: tmpl.Name(), OrigErr: err}
+	}
+	return s.run(&migration)
+}
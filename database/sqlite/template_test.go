@@ -0,0 +1,40 @@
+package sqlite
+
+import (
+	"fmt"
+	"testing"
+	"text/template"
+)
+
+func TestRunTemplate(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "")
+	defer d.Close()
+
+	tmpl := template.Must(template.New("tenants").Parse(`{{range .}}
+CREATE TABLE tenant_{{.}} (id int);
+{{end}}`))
+	if err := d.RunTemplate(tmpl, []int{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		if !tableExists(t, d, "main", fmt.Sprintf("tenant_%v", i)) {
+			t.Fatalf("expected table tenant_%v to exist", i)
+		}
+	}
+
+	broken := template.Must(template.New("broken").Parse(`CREATE TABLE {{.Name}} (id int);`))
+	err := d.RunTemplate(broken, 42)
+	if e, ok := err.(ErrTemplate); !ok || e.Name != "broken" {
+		t.Fatalf("expected ErrTemplate, got %v", err)
+	}
+
+	// SQL errors aren't template errors
+	if err := d.RunTemplate(tmpl, []int{1}); err == nil {
+		t.Fatalf("expected table tenant_1 to exist already")
+	} else if _, ok := err.(ErrTemplate); ok {
+		t.Fatalf("expected an SQL error, got %v", err)
+	}
+}
@@ -0,0 +1,18 @@
+package sqlite
+
+// nowExpr is the current time in UTC with milliseconds and a zone offset,
+// close to the format go-sqlite3 writes time.Time values in.
+// https://www.sqlite.org/lang_datefunc.html
+const nowExpr = `strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')`
+
+// NowExpr returns the SQL expression for the current time in UTC, with
+// millisecond precision and a zone offset: 2006-01-02 15:04:05.000+00:00.
+// go-sqlite3 writes time.Time values as 2006-01-02 15:04:05.999999999-07:00,
+// which has up to nanoseconds and drops trailing zeros of the fraction.
+// Use it in migrations instead of CURRENT_TIMESTAMP, which has neither
+// fraction nor offset, so times written by migrations and by Go in UTC,
+// like applied_at, sort as text to the second, and parse back into the
+// same time.Time.
+func NowExpr() string {
+	return nowExpr
+}
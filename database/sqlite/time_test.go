@@ -0,0 +1,67 @@
+package sqlite
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestNowExpr(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := open(t, dir, "?x-utc-times=on")
+	defer d.Close()
+
+	before := time.Now().UTC().Truncate(time.Millisecond)
+	migration := "CREATE TABLE events (name text, at datetime not null DEFAULT (" + NowExpr() + "));" +
+		"INSERT INTO events (name, at) VALUES ('migration', " + NowExpr() + ");"
+	if err := d.Run(bytes.NewReader([]byte(migration))); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if _, err := d.db.Exec(`INSERT INTO events (name, at) VALUES ('go', ?)`, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now().UTC()
+
+	// both rows are written in UTC with an offset, the migration to the millisecond
+	var migrationText, goText string
+	if err := d.db.QueryRow(`SELECT (SELECT CAST(at AS text) FROM events WHERE name = 'migration'), (SELECT CAST(at AS text) FROM events WHERE name = 'go')`).Scan(&migrationText, &goText); err != nil {
+		t.Fatal(err)
+	}
+	if migrationText[19:20] != "." || migrationText[len(migrationText)-6:] != "+00:00" || goText[len(goText)-6:] != "+00:00" {
+		t.Fatalf("expected both times in UTC with fractional seconds, got %q and %q", migrationText, goText)
+	}
+
+	rows, err := d.db.Query(`SELECT name, at FROM events ORDER BY at`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		var at time.Time
+		if err := rows.Scan(&name, &at); err != nil {
+			t.Fatal(err)
+		}
+		if at.Location() != time.UTC {
+			t.Fatalf("expected %v to be read in UTC, got %v", name, at.Location())
+		}
+		if at.Before(before) || at.After(after) {
+			t.Fatalf("expected %v between %v and %v, got %v", name, before, after, at)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "migration" {
+		t.Fatalf("expected the migration's row first, got %q", names)
+	}
+
+	if _, err := (&Sqlite{}).Open("sqlite3://" + dir + "/other.db?x-utc-times=on&_loc=auto"); err == nil {
+		t.Fatal("expected x-utc-times with _loc=auto to fail")
+	}
+}
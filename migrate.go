@@ -698,6 +698,9 @@ func (m *Migrate) runMigrations(ret <-chan interface{}) error {
 
 			if migr.Body != nil {
 				m.logVerbosePrintf("Read and execute %v\n", migr.LogString())
+				if t, ok := m.databaseDrv.(database.VersionTracker); ok {
+					t.SetCurrentVersion(int(migr.Version))
+				}
 				if err := m.databaseDrv.Run(migr.BufferedBody); err != nil {
 					return err
 				}